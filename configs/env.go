@@ -3,6 +3,7 @@ package configs
 import (
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
@@ -14,3 +15,21 @@ func GetEnv(key string) string {
 	}
 	return os.Getenv(key)
 }
+
+// GetEnvInt reads an integer env variable, falling back when it is unset or invalid
+func GetEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(GetEnv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// GetEnvBool reads a boolean env variable, falling back when it is unset or invalid
+func GetEnvBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(GetEnv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
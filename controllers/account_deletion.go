@@ -0,0 +1,334 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+	"github.com/achintya-7/go-fiber-chat/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// what happens to the messages of a deleted account: "anonymize" keeps them without an
+// author, "delete" removes them along with their reactions
+var messageDeletionPolicy = configs.GetEnv("MESSAGE_DELETION_POLICY")
+
+// the name shown for the other side of a direct chat whose user deleted their account
+const deletedUserName = "Deleted user"
+
+// deleteUserSteps remove an account and everything pointing at it, in this order
+var deleteUserSteps = []jobStep{
+	lockDeletedUser,
+	deleteOwnedBots,
+	leaveGroupsAndChannels,
+	markDirectChatsDeleted,
+	removeDeletedUserMessages,
+	removeDeletedUserRecords,
+	removeDeletedUser,
+}
+
+// lockDeletedUser stops the account from signing in and signs it out everywhere
+func lockDeletedUser(ctx context.Context, job *models.Job) error {
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "deleting", Value: true}}}}
+	if _, err := userCollection.UpdateOne(ctx, bson.D{{Key: "id", Value: job.UserId}}, update); err != nil {
+		return err
+	}
+	if err := revokeSessions(ctx, job.UserId, primitive.NilObjectID); err != nil {
+		return err
+	}
+	return revokeAPIKeys(ctx, job.UserId)
+}
+
+// deleteOwnedBots queues the deletion of the user's bots, which cant outlive their owner
+func deleteOwnedBots(ctx context.Context, job *models.Job) error {
+	filter := bson.D{{Key: "isbot", Value: true}, {Key: "ownerid", Value: job.UserId}, {Key: "deleting", Value: bson.D{{Key: "$ne", Value: true}}}}
+	cursor, err := userCollection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var bots []models.User
+	if err := cursor.All(ctx, &bots); err != nil {
+		return err
+	}
+
+	for _, bot := range bots {
+		if _, err := enqueueJob(ctx, models.JobDeleteUser, bot.Id); err != nil {
+			return err
+		}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "deleting", Value: true}}}}
+		if _, err := userCollection.UpdateOne(ctx, bson.D{{Key: "id", Value: bot.Id}}, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// leaveGroupsAndChannels takes the user out of every group and channel. Ownership passes to
+// the longest standing remaining member, or for a channel without other admins to its oldest
+// subscriber, skipping users being deleted too. Chats left with nobody in them are removed.
+func leaveGroupsAndChannels(ctx context.Context, job *models.Job) error {
+	filter := bson.D{
+		{Key: "users", Value: job.UserId},
+		{Key: "chattype", Value: bson.D{{Key: "$in", Value: bson.A{models.ChatTypeGroup, models.ChatTypeChannel}}}},
+	}
+	cursor, err := chatCollection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	var chats []models.ChannelRes
+	if err := cursor.All(ctx, &chats); err != nil {
+		return err
+	}
+
+	for _, chat := range chats {
+		remaining := dedupeUserIds(chat.Users, job.UserId)
+		heir, err := firstActiveUser(ctx, remaining)
+		if err != nil {
+			return err
+		}
+
+		// a channel whose admins are all gone is handed to a subscriber rather than removed
+		if heir.IsZero() && chat.ChatType == models.ChatTypeChannel {
+			if heir, err = oldestActiveSubscriber(ctx, chat.ChatId); err != nil {
+				return err
+			}
+			if !heir.IsZero() {
+				promote := bson.D{
+					{Key: "$addToSet", Value: bson.D{{Key: "users", Value: heir}}},
+					{Key: "$set", Value: bson.D{{Key: "userid", Value: heir}}},
+				}
+				if _, err := chatCollection.UpdateOne(ctx, bson.D{{Key: "chatid", Value: chat.ChatId}}, promote); err != nil {
+					return err
+				}
+				// admins read the channel as one of its users, not as a subscriber
+				if _, err := setSubscription(ctx, chat.ChatId, heir, false); err != nil {
+					return err
+				}
+				remaining = append(remaining, heir)
+			}
+		}
+
+		if len(remaining) == 0 {
+			if err := removeChat(ctx, chat.ChatId); err != nil {
+				return err
+			}
+			continue
+		}
+		// when everyone left is being deleted too, their own jobs pass the chat on
+		if heir.IsZero() {
+			heir = remaining[0]
+		}
+
+		update := bson.D{{Key: "$pull", Value: bson.D{{Key: "users", Value: job.UserId}}}}
+		if chat.UserId == job.UserId {
+			update = append(update, bson.E{Key: "$set", Value: bson.D{{Key: "userid", Value: heir}}})
+		}
+		if _, err := chatCollection.UpdateOne(ctx, bson.D{{Key: "chatid", Value: chat.ChatId}}, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// firstActiveUser is the first of the users, in order, whose account is not being deleted
+func firstActiveUser(ctx context.Context, userIds []primitive.ObjectID) (primitive.ObjectID, error) {
+	if len(userIds) == 0 {
+		return primitive.NilObjectID, nil
+	}
+	filter := bson.D{{Key: "id", Value: bson.D{{Key: "$in", Value: userIds}}}, {Key: "deleting", Value: bson.D{{Key: "$ne", Value: true}}}}
+	cursor, err := userCollection.Find(ctx, filter, options.Find().SetProjection(bson.D{{Key: "id", Value: 1}}))
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	active := make(map[primitive.ObjectID]bool, len(users))
+	for _, user := range users {
+		active[user.Id] = true
+	}
+	for _, id := range userIds {
+		if active[id] {
+			return id, nil
+		}
+	}
+	return primitive.NilObjectID, nil
+}
+
+// oldestActiveSubscriber is the channel's longest standing subscriber not being deleted, if any
+func oldestActiveSubscriber(ctx context.Context, chatId primitive.ObjectID) (primitive.ObjectID, error) {
+	cursor, err := membershipCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "chatid", Value: chatId}, {Key: "subscribed", Value: true}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+		{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: "users"},
+			{Key: "localField", Value: "userid"},
+			{Key: "foreignField", Value: "id"},
+			{Key: "as", Value: "user"},
+		}}},
+		{{Key: "$match", Value: bson.D{{Key: "user.0", Value: bson.D{{Key: "$exists", Value: true}}}, {Key: "user.deleting", Value: bson.D{{Key: "$ne", Value: true}}}}}},
+		{{Key: "$limit", Value: 1}},
+	})
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	var subscribers []models.ChatMembership
+	if err := cursor.All(ctx, &subscribers); err != nil {
+		return primitive.NilObjectID, err
+	}
+	if len(subscribers) == 0 {
+		return primitive.NilObjectID, nil
+	}
+	return subscribers[0].UserId, nil
+}
+
+// markDirectChatsDeleted keeps direct chats for the other user, shown as with a deleted
+// user. Notes to self have nobody left to read them and are removed.
+func markDirectChatsDeleted(ctx context.Context, job *models.Job) error {
+	cursor, err := chatCollection.Find(ctx, bson.D{{Key: "chattype", Value: models.ChatTypeDirect}, {Key: "users", Value: bson.A{job.UserId}}})
+	if err != nil {
+		return err
+	}
+	var notes []models.CreateChatRes
+	if err := cursor.All(ctx, &notes); err != nil {
+		return err
+	}
+	for _, note := range notes {
+		if err := removeChat(ctx, note.ChatId); err != nil {
+			return err
+		}
+	}
+
+	update := bson.D{{Key: "$addToSet", Value: bson.D{{Key: "deletedusers", Value: job.UserId}}}}
+	_, err = chatCollection.UpdateMany(ctx, bson.D{{Key: "chattype", Value: models.ChatTypeDirect}, {Key: "users", Value: job.UserId}}, update)
+	return err
+}
+
+// removeDeletedUserMessages applies the message deletion policy to everything the user sent
+func removeDeletedUserMessages(ctx context.Context, job *models.Job) error {
+	filter := bson.D{{Key: "userid", Value: job.UserId}}
+
+	if messageDeletionPolicy != "delete" {
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "userid", Value: primitive.NilObjectID}}}}
+		_, err := messageCollection.UpdateMany(ctx, filter, update)
+		return err
+	}
+
+	// reactions go first so a retry still finds the messages they belong to
+	for {
+		cursor, err := messageCollection.Find(ctx, filter, options.Find().SetProjection(bson.D{{Key: "messageid", Value: 1}}).SetLimit(1000))
+		if err != nil {
+			return err
+		}
+		var messages []models.Message
+		if err := cursor.All(ctx, &messages); err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+
+		ids := make([]string, 0, len(messages))
+		for _, message := range messages {
+			ids = append(ids, message.MessageId)
+		}
+		if _, err := reactionCollection.DeleteMany(ctx, bson.D{{Key: "messageid", Value: bson.D{{Key: "$in", Value: ids}}}}); err != nil {
+			return err
+		}
+		if _, err := messageCollection.DeleteMany(ctx, bson.D{{Key: "userid", Value: job.UserId}, {Key: "messageid", Value: bson.D{{Key: "$in", Value: ids}}}}); err != nil {
+			return err
+		}
+	}
+}
+
+// removeDeletedUserRecords drops the user's reactions, chat settings and channel subscriptions,
+// blocks, contacts and tokens
+func removeDeletedUserRecords(ctx context.Context, job *models.Job) error {
+	either := func(first string, second string) bson.D {
+		return bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: first, Value: job.UserId}},
+			bson.D{{Key: second, Value: job.UserId}},
+		}}}
+	}
+
+	if _, err := reactionCollection.DeleteMany(ctx, bson.D{{Key: "userid", Value: job.UserId}}); err != nil {
+		return err
+	}
+	// unsubscribing first keeps the channels' subscriber counts right, and only counts down
+	// once if the step is run again
+	channelIds, err := subscribedChannelIds(ctx, job.UserId)
+	if err != nil {
+		return err
+	}
+	for _, chatId := range channelIds {
+		if _, err := setSubscription(ctx, chatId, job.UserId, false); err != nil {
+			return err
+		}
+	}
+	if _, err := membershipCollection.DeleteMany(ctx, bson.D{{Key: "userid", Value: job.UserId}}); err != nil {
+		return err
+	}
+	if _, err := blockCollection.DeleteMany(ctx, either("blockerid", "blockedid")); err != nil {
+		return err
+	}
+	if _, err := contactCollection.DeleteMany(ctx, either("fromid", "toid")); err != nil {
+		return err
+	}
+	if _, err := tokenCollection.DeleteMany(ctx, bson.D{{Key: "userid", Value: job.UserId}}); err != nil {
+		return err
+	}
+	if _, err := groupInviteCollection.DeleteMany(ctx, either("fromid", "toid")); err != nil {
+		return err
+	}
+	if _, err := apiKeyCollection.DeleteMany(ctx, either("userid", "ownerid")); err != nil {
+		return err
+	}
+	if _, err := identityCollection.DeleteMany(ctx, bson.D{{Key: "userid", Value: job.UserId}}); err != nil {
+		return err
+	}
+	if _, err := auditCollection.DeleteMany(ctx, bson.D{{Key: "userid", Value: job.UserId}}); err != nil {
+		return err
+	}
+	if _, err := passkeyCollection.DeleteMany(ctx, bson.D{{Key: "userid", Value: job.UserId}}); err != nil {
+		return err
+	}
+	return revokeSessions(ctx, job.UserId, primitive.NilObjectID)
+}
+
+// removeDeletedUser deletes the user document and their avatar
+func removeDeletedUser(ctx context.Context, job *models.Job) error {
+	var user models.User
+	if err := userCollection.FindOne(ctx, bson.D{{Key: "id", Value: job.UserId}}).Decode(&user); err != nil {
+		// already removed by an earlier run
+		return nil
+	}
+
+	if _, err := userCollection.DeleteOne(ctx, bson.D{{Key: "id", Value: job.UserId}}); err != nil {
+		return err
+	}
+	removeUpload(user.AvatarUrl)
+	return nil
+}
+
+// removeChat deletes a chat with its messages, reactions and member settings
+func removeChat(ctx context.Context, chatId primitive.ObjectID) error {
+	if _, err := reactionCollection.DeleteMany(ctx, bson.D{{Key: "roomid", Value: chatId}}); err != nil {
+		return err
+	}
+	if _, err := messageCollection.DeleteMany(ctx, bson.D{{Key: "roomid", Value: chatId}}); err != nil {
+		return err
+	}
+	if _, err := membershipCollection.DeleteMany(ctx, bson.D{{Key: "chatid", Value: chatId}}); err != nil {
+		return err
+	}
+	if _, err := groupInviteCollection.DeleteMany(ctx, bson.D{{Key: "chatid", Value: chatId}}); err != nil {
+		return err
+	}
+	_, err := chatCollection.DeleteOne(ctx, bson.D{{Key: "chatid", Value: chatId}})
+	return err
+}
@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/responses"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// bots have no mailbox, they get an address under a reserved domain that never receives mail
+const botEmailDomain = "bots.invalid"
+
+// CreateBot makes a bot account owned by the signed in user. Bots have no password and are
+// only used through API keys.
+func CreateBot(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	var req models.CreateBotReq
+	var owner models.User
+	defer cancel()
+
+	ownerId := authFrom(c).UserId
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": validationErr.Error()}})
+	}
+
+	filter := bson.D{{Key: "id", Value: ownerId}, {Key: "deleting", Value: bson.D{{Key: "$ne", Value: true}}}}
+	if err := userCollection.FindOne(ctx, filter).Decode(&owner); err != nil {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "User with specified ID not found!"}})
+	}
+	if owner.IsBot {
+		return c.Status(http.StatusForbidden).JSON(responses.UserResponse{Status: http.StatusForbidden, Message: "error", Data: &fiber.Map{"data": "Bots cant own bots"}})
+	}
+
+	bot := models.User{
+		Id:            primitive.NewObjectID(),
+		Name:          strings.TrimSpace(req.Name),
+		EmailVerified: true,
+		IsBot:         true,
+		OwnerId:       ownerId,
+	}
+	bot.Email = bot.Id.Hex() + "@" + botEmailDomain
+	if req.Handle != "" {
+		handle, ok := models.NormalizeHandle(req.Handle)
+		if !ok {
+			return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "A handle is 3 to 30 letters, digits or underscores"}})
+		}
+		bot.Handle = handle
+	}
+	bot.SetSearchKeys()
+
+	if _, err := userCollection.InsertOne(ctx, bot); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(http.StatusConflict).JSON(responses.UserResponse{Status: http.StatusConflict, Message: "error", Data: &fiber.Map{"data": "Handle is already taken"}})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusCreated).JSON(responses.UserResponse{Status: http.StatusCreated, Message: "success", Data: &fiber.Map{"data": models.ToPublicUser(bot)}})
+}
+
+func GetBots(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "isbot", Value: true}, {Key: "ownerid", Value: authFrom(c).UserId}, {Key: "deleting", Value: bson.D{{Key: "$ne", Value: true}}}}
+	cursor, err := userCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "id", Value: 1}}))
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	var bots []models.User
+	if err := cursor.All(ctx, &bots); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": models.ToPublicUsers(bots)}})
+}
+
+// DeleteBot stops the bot's keys right away and deletes it like any other account
+func DeleteBot(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	botId, _ := primitive.ObjectIDFromHex(c.Params("botId"))
+
+	filter := bson.D{{Key: "id", Value: botId}, {Key: "isbot", Value: true}, {Key: "ownerid", Value: authFrom(c).UserId}}
+	result, err := userCollection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: bson.D{{Key: "deleting", Value: true}}}})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if result.MatchedCount < 1 {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "Bot not found"}})
+	}
+
+	if err := revokeAPIKeys(ctx, botId); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	job, err := enqueueJob(ctx, models.JobDeleteUser, botId)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusAccepted).JSON(responses.UserResponse{Status: http.StatusAccepted, Message: "Bot is being deleted", Data: &fiber.Map{"data": job}})
+}
+
+// CreateAPIKey makes a key acting as the signed in user or one of their bots. The key is
+// only in this response, afterwards just its prefix is shown.
+func CreateAPIKey(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	var req models.CreateAPIKeyReq
+	defer cancel()
+
+	ownerId := authFrom(c).UserId
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": validationErr.Error()}})
+	}
+
+	if len(req.Scopes) == 0 {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "A key needs at least one scope"}})
+	}
+	for _, scope := range req.Scopes {
+		if !scope.Valid() {
+			return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Unknown scope " + string(scope)}})
+		}
+	}
+
+	userId := ownerId
+	if !req.BotId.IsZero() {
+		filter := bson.D{{Key: "id", Value: req.BotId}, {Key: "isbot", Value: true}, {Key: "ownerid", Value: ownerId}, {Key: "deleting", Value: bson.D{{Key: "$ne", Value: true}}}}
+		count, err := userCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+		}
+		if count == 0 {
+			return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "Bot not found"}})
+		}
+		userId = req.BotId
+	}
+
+	token, prefix, err := models.NewAPIKey()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	chatIds := req.ChatIds
+	if chatIds == nil {
+		chatIds = []primitive.ObjectID{}
+	}
+	key := models.APIKey{
+		KeyId:     primitive.NewObjectID(),
+		Prefix:    prefix,
+		KeyHash:   models.HashToken(token),
+		UserId:    userId,
+		OwnerId:   ownerId,
+		Name:      strings.TrimSpace(req.Name),
+		Scopes:    req.Scopes,
+		ChatIds:   chatIds,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	if _, err := apiKeyCollection.InsertOne(ctx, key); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(http.StatusCreated).JSON(responses.UserResponse{Status: http.StatusCreated, Message: "success", Data: &fiber.Map{"data": key, "key": token}})
+}
+
+func GetAPIKeys(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdat", Value: -1}})
+	cursor, err := apiKeyCollection.Find(ctx, bson.D{{Key: "ownerid", Value: authFrom(c).UserId}}, opts)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	keys := []models.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": keys}})
+}
+
+// RevokeAPIKey stops a key for good. Revoked keys stay listed with when they were revoked.
+func RevokeAPIKey(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	keyId, _ := primitive.ObjectIDFromHex(c.Params("keyId"))
+
+	filter := bson.D{{Key: "keyid", Value: keyId}, {Key: "ownerid", Value: authFrom(c).UserId}, {Key: "revokedat", Value: int64(0)}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "revokedat", Value: time.Now().UnixMilli()}}}}
+	result, err := apiKeyCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if result.MatchedCount < 1 {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "API key not found"}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "API key revoked"}})
+}
@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/mailer"
+	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/passwords"
+	"github.com/achintya-7/go-fiber-chat/responses"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const passwordResetTTL = time.Hour
+
+// ForgotPassword mails a reset token to the account with this email. It answers the same
+// whether or not the account exists, so it cant be used to find out who has one, and limits
+// how often an email can be sent codes, also whether or not it exists.
+func ForgotPassword(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	var req models.ForgotPasswordReq
+	defer cancel()
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": validationErr.Error()}})
+	}
+
+	email := models.NormalizeEmail(req.Email)
+	allowed, err := passwordResetLimit.allow(ctx, email, c.IP())
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if !allowed {
+		return c.Status(http.StatusTooManyRequests).JSON(responses.UserResponse{Status: http.StatusTooManyRequests, Message: "error", Data: &fiber.Map{"data": "Too many reset codes, try again later"}})
+	}
+
+	var user models.User
+	// bots have no password to reset
+	filter := bson.D{{Key: "email", Value: email}, {Key: "deleting", Value: bson.D{{Key: "$ne", Value: true}}}, {Key: "isbot", Value: bson.D{{Key: "$ne", Value: true}}}}
+	if err := userCollection.FindOne(ctx, filter).Decode(&user); err == nil {
+		token, err := issueToken(ctx, user.Id, models.TokenPasswordReset, user.Email, passwordResetTTL)
+		if err != nil {
+			log.Print(err)
+		} else {
+			// sent in the background so a slow mail server doesnt make known emails answer slower
+			go func() {
+				if err := mailer.Default.Send(user.Email, "Reset your password", "Use this code to set a new password: "+token+"\nIt expires in one hour. If you did not ask for it, you can ignore this email."); err != nil {
+					log.Print(err)
+				}
+			}()
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "If an account uses this email, a reset code was sent to it"}})
+}
+
+// ResetPassword sets a new password with a token from ForgotPassword and signs the account
+// out everywhere
+func ResetPassword(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	var req models.ResetPasswordReq
+	defer cancel()
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": validationErr.Error()}})
+	}
+
+	// a weak password is turned down before the token is used up, so it can be tried again
+	if err := passwords.Check(req.NewPassword); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	hash, err := passwords.Hash(req.NewPassword)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	token, err := consumeToken(ctx, req.Token, models.TokenPasswordReset)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": errInvalidToken.Error()}})
+	}
+
+	result, err := userCollection.UpdateOne(ctx, bson.D{{Key: "id", Value: token.UserId}, {Key: "deleting", Value: bson.D{{Key: "$ne", Value: true}}}}, bson.D{{Key: "$set", Value: bson.D{{Key: "password", Value: hash}}}})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if result.MatchedCount < 1 {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": errInvalidToken.Error()}})
+	}
+
+	// any other reset codes still out there stop working along with the old password
+	unused := bson.D{{Key: "userid", Value: token.UserId}, {Key: "purpose", Value: models.TokenPasswordReset}, {Key: "used", Value: false}}
+	if _, err := tokenCollection.UpdateMany(ctx, unused, bson.D{{Key: "$set", Value: bson.D{{Key: "used", Value: true}}}}); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if err := revokeSessions(ctx, token.UserId, primitive.NilObjectID); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "Password changed"}})
+}
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/responses"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var apiKeyCollection *mongo.Collection = configs.GetCollection(configs.DB, "api_keys")
+
+// last use of an API key is recorded at most this often, not on every request
+const apiKeyUseInterval = time.Minute
+
+// authInfo is who a request was authenticated as. Key is only set for API key requests.
+type authInfo struct {
+	UserId primitive.ObjectID
+	Key    *models.APIKey
+}
+
+const authLocal = "auth"
+
+// Authenticate lets a request through with a session token or an API key with the scope,
+// sent as "Authorization: Bearer <token>" or, for keys, also as "X-API-Key: <key>"
+func Authenticate(scope models.APIKeyScope) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		token := c.Get("X-API-Key")
+		if token == "" {
+			token = strings.TrimSpace(strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "))
+		}
+		if token == "" {
+			return c.Status(http.StatusUnauthorized).JSON(responses.UserResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Sign in or use an API key"}})
+		}
+
+		if !models.IsAPIKey(token) {
+			session, err := sessionFromRequest(ctx, c)
+			if err != nil {
+				return c.Status(http.StatusUnauthorized).JSON(responses.UserResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": errInvalidToken.Error()}})
+			}
+			c.Locals(authLocal, &authInfo{UserId: session.UserId})
+			return c.Next()
+		}
+
+		key, err := findAPIKey(ctx, token)
+		if err != nil {
+			return c.Status(http.StatusUnauthorized).JSON(responses.UserResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "API key is invalid or revoked"}})
+		}
+		if !key.Allows(scope) {
+			return c.Status(http.StatusForbidden).JSON(responses.UserResponse{Status: http.StatusForbidden, Message: "error", Data: &fiber.Map{"data": "API key lacks the " + string(scope) + " scope"}})
+		}
+		c.Locals(authLocal, &authInfo{UserId: key.UserId, Key: key})
+		return c.Next()
+	}
+}
+
+// RequireSession lets a request through only with a session of the user in the path, for
+// managing the account. API keys cant be used here, so a leaked key cant mint more keys.
+func RequireSession(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, _ := primitive.ObjectIDFromHex(c.Params("userId"))
+
+	session, err := sessionFromRequest(ctx, c)
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(responses.UserResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": errInvalidToken.Error()}})
+	}
+	if session.UserId != userId {
+		return c.Status(http.StatusForbidden).JSON(responses.UserResponse{Status: http.StatusForbidden, Message: "error", Data: &fiber.Map{"data": "Signed in as another user"}})
+	}
+	c.Locals(authLocal, &authInfo{UserId: session.UserId})
+	return c.Next()
+}
+
+// RequireSignIn lets a request through with a session of any user, for routes that act as
+// whoever is signed in rather than the user in the path
+func RequireSignIn(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	session, err := sessionFromRequest(ctx, c)
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(responses.UserResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": errInvalidToken.Error()}})
+	}
+	c.Locals(authLocal, &authInfo{UserId: session.UserId})
+	return c.Next()
+}
+
+// findAPIKey looks up a live key and records that it was used
+func findAPIKey(ctx context.Context, token string) (*models.APIKey, error) {
+	filter := bson.D{{Key: "keyhash", Value: models.HashToken(token)}, {Key: "revokedat", Value: int64(0)}}
+
+	var key models.APIKey
+	if err := apiKeyCollection.FindOne(ctx, filter).Decode(&key); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if now.Sub(time.UnixMilli(key.LastUsedAt)) > apiKeyUseInterval {
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "lastusedat", Value: now.UnixMilli()}}}}
+		if _, err := apiKeyCollection.UpdateOne(ctx, bson.D{{Key: "keyid", Value: key.KeyId}}, update); err != nil {
+			return nil, err
+		}
+		key.LastUsedAt = now.UnixMilli()
+	}
+	return &key, nil
+}
+
+// authFrom is who Authenticate let the request through as
+func authFrom(c *fiber.Ctx) *authInfo {
+	auth, _ := c.Locals(authLocal).(*authInfo)
+	if auth == nil {
+		return &authInfo{}
+	}
+	return auth
+}
+
+// allowsChat tells if the request may touch the chat. Sessions may touch any chat their user
+// is in, API keys can be limited to a few.
+func (auth *authInfo) allowsChat(chatId primitive.ObjectID) bool {
+	return auth.Key == nil || auth.Key.AllowsChat(chatId)
+}
+
+// revokeAPIKeys stops every key acting as or managed by the user
+func revokeAPIKeys(ctx context.Context, userId primitive.ObjectID) error {
+	filter := bson.D{
+		{Key: "revokedat", Value: int64(0)},
+		{Key: "$or", Value: bson.A{bson.D{{Key: "userid", Value: userId}}, bson.D{{Key: "ownerid", Value: userId}}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "revokedat", Value: time.Now().UnixMilli()}}}}
+	_, err := apiKeyCollection.UpdateMany(ctx, filter, update)
+	return err
+}
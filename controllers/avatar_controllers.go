@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	// decoders for the formats an avatar can be uploaded in
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/responses"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UploadDir is where uploaded files are stored and served from under /uploads
+var UploadDir = uploadDir()
+
+func uploadDir() string {
+	if dir := configs.GetEnv("UPLOAD_DIR"); dir != "" {
+		return dir
+	}
+	return "uploads"
+}
+
+// avatars are cropped to a square of this many pixels
+const avatarSize = 256
+
+// larger images are refused before they are decoded
+const maxAvatarPixels = 4096 * 4096
+
+func UploadAvatar(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	userId := c.Params("userId")
+	var user models.User
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(userId)
+
+	if err := userCollection.FindOne(ctx, bson.D{{Key: "id", Value: objId}}).Decode(&user); err != nil {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "User with specified ID not found!"}})
+	}
+
+	header, err := c.FormFile("avatar")
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Send the image as the avatar form field"}})
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil || config.Width*config.Height > maxAvatarPixels {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Avatar must be a JPEG, PNG or GIF of at most 4096x4096"}})
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	src, _, err := image.Decode(file)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	dir := filepath.Join(UploadDir, "avatars")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	// a new name per upload so cached copies of the old avatar are never served
+	name := fmt.Sprintf("%s-%s.jpg", objId.Hex(), primitive.NewObjectID().Hex())
+	out, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	err = jpeg.Encode(out, resizeSquare(src, avatarSize), &jpeg.Options{Quality: 85})
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	avatarUrl := "/uploads/avatars/" + name
+	if _, err := userCollection.UpdateOne(ctx, bson.D{{Key: "id", Value: objId}}, bson.D{{Key: "$set", Value: bson.D{{Key: "avatarurl", Value: avatarUrl}}}}); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	removeUpload(user.AvatarUrl)
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": fiber.Map{"avatarUrl": avatarUrl}}})
+}
+
+// removeUpload deletes a previously stored upload by its public url
+func removeUpload(url string) {
+	if !strings.HasPrefix(url, "/uploads/") {
+		return
+	}
+	path := filepath.Join(UploadDir, filepath.FromSlash(strings.TrimPrefix(url, "/uploads/")))
+	os.Remove(path)
+}
+
+// resizeSquare crops the middle square of an image and scales it to size x size,
+// averaging the source pixels that fall into each target pixel
+func resizeSquare(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	left := bounds.Min.X + (bounds.Dx()-side)/2
+	top := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0 := top + y*side/size
+		y1 := top + (y+1)*side/size
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < size; x++ {
+			x0 := left + x*side/size
+			x1 := left + (x+1)*side/size
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/responses"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var blockCollection *mongo.Collection = configs.GetCollection(configs.DB, "blocks")
+
+func BlockUser(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, _ := primitive.ObjectIDFromHex(c.Params("userId"))
+
+	var req models.BlockReq
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if req.TargetId == userId {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "You cant block yourself"}})
+	}
+
+	invalid, err := findInvalidUsers(ctx, []primitive.ObjectID{userId, req.TargetId})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if len(invalid) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "Some users are invalid", Data: &fiber.Map{"data": invalid}})
+	}
+
+	block := models.Block{BlockerId: userId, BlockedId: req.TargetId, CreatedAt: time.Now().UnixMilli()}
+	if _, err := blockCollection.InsertOne(ctx, block); err != nil && !mongo.IsDuplicateKeyError(err) {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": block}})
+}
+
+func UnblockUser(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, _ := primitive.ObjectIDFromHex(c.Params("userId"))
+	targetId, _ := primitive.ObjectIDFromHex(c.Params("targetId"))
+
+	result, err := blockCollection.DeleteOne(ctx, bson.D{{Key: "blockerid", Value: userId}, {Key: "blockedid", Value: targetId}})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if result.DeletedCount < 1 {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "User is not blocked"}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "User unblocked"}})
+}
+
+func GetBlockedUsers(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, _ := primitive.ObjectIDFromHex(c.Params("userId"))
+
+	cursor, err := blockCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "blockerid", Value: userId}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "createdat", Value: -1}}}},
+		{
+			{
+				Key: "$lookup",
+				Value: bson.D{
+					{Key: "from", Value: "users"},
+					{Key: "localField", Value: "blockedid"},
+					{Key: "foreignField", Value: "id"},
+					{Key: "as", Value: "user"},
+				},
+			},
+		},
+		{{Key: "$unwind", Value: "$user"}},
+		{{Key: "$replaceRoot", Value: bson.D{{Key: "newRoot", Value: bson.D{
+			{Key: "id", Value: "$user.id"},
+			{Key: "name", Value: "$user.name"},
+		}}}}},
+	})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	users := []models.UserInfo{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": users}})
+}
+
+// isBlockedEither tells if one of the two users has blocked the other
+func isBlockedEither(ctx context.Context, userId primitive.ObjectID, otherId primitive.ObjectID) (bool, error) {
+	count, err := blockCollection.CountDocuments(ctx, bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "blockerid", Value: userId}, {Key: "blockedid", Value: otherId}},
+		bson.D{{Key: "blockerid", Value: otherId}, {Key: "blockedid", Value: userId}},
+	}}})
+	return count > 0, err
+}
+
+// findBlockingUsers reports every user in ids that has blocked the actor
+func findBlockingUsers(ctx context.Context, actorId primitive.ObjectID, ids []primitive.ObjectID) ([]models.InvalidUser, error) {
+	blocking := []models.InvalidUser{}
+	if len(ids) == 0 {
+		return blocking, nil
+	}
+
+	cursor, err := blockCollection.Find(ctx, bson.D{{Key: "blockedid", Value: actorId}, {Key: "blockerid", Value: bson.D{{Key: "$in", Value: ids}}}})
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []models.Block
+	if err := cursor.All(ctx, &blocks); err != nil {
+		return nil, err
+	}
+	for _, block := range blocks {
+		blocking = append(blocking, models.InvalidUser{UserId: block.BlockerId, Reason: "user has blocked you"})
+	}
+	return blocking, nil
+}
+
+// blockedUserIds lists everyone the user blocked or was blocked by
+func blockedUserIds(ctx context.Context, userId primitive.ObjectID) ([]primitive.ObjectID, error) {
+	cursor, err := blockCollection.Find(ctx, bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "blockerid", Value: userId}},
+		bson.D{{Key: "blockedid", Value: userId}},
+	}}})
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []models.Block
+	if err := cursor.All(ctx, &blocks); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(blocks))
+	for _, block := range blocks {
+		if block.BlockerId == userId {
+			ids = append(ids, block.BlockedId)
+		} else {
+			ids = append(ids, block.BlockerId)
+		}
+	}
+	return ids, nil
+}
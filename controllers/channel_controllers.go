@@ -0,0 +1,326 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/responses"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func CreateChannel(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.CreateChannelReq
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Unable to parse JSON",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+	// the user acting is whoever is signed in, whatever the body says
+	req.UserId = authFrom(c).UserId
+
+	if strings.TrimSpace(req.ChatName) == "" {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "A channel needs a name",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	blocked, err := blockedUnverified(ctx, req.UserId, actionCreateChannel)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+	if blocked {
+		return c.Status(http.StatusForbidden).JSON(responses.UserResponse{
+			Status:  http.StatusForbidden,
+			Message: "Verify your email to create channels",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	invalid, err := findInvalidUsers(ctx, []primitive.ObjectID{req.UserId})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+	if len(invalid) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Some users are invalid",
+			Data:    &fiber.Map{"data": invalid},
+		})
+	}
+
+	channel := models.ChannelRes{
+		ChatId:          primitive.NewObjectID(),
+		Users:           []primitive.ObjectID{req.UserId},
+		ChatType:        models.ChatTypeChannel,
+		LatestMessage:   "",
+		LatestMessageId: "",
+		UserId:          req.UserId,
+		ChatName:        req.ChatName,
+		LastActivityAt:  time.Now().UnixMilli(),
+		SubscriberCount: 0,
+	}
+
+	if _, err := chatCollection.InsertOne(ctx, channel); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{
+		Status:  200,
+		Message: "Channel Created",
+		Data:    &fiber.Map{"data": channel},
+	})
+}
+
+func SubscribeChannel(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.ChannelSubscriptionReq
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Unable to parse JSON",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+	// the user acting is whoever is signed in, whatever the body says
+	req.UserId = authFrom(c).UserId
+
+	filter := bson.D{{Key: "chatid", Value: req.ChatId}, {Key: "chattype", Value: models.ChatTypeChannel}}
+	if err := chatCollection.FindOne(ctx, filter).Err(); err != nil {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{
+			Status:  http.StatusNotFound,
+			Message: "Channel not found",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	invalid, err := findInvalidUsers(ctx, []primitive.ObjectID{req.UserId})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+	if len(invalid) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Some users are invalid",
+			Data:    &fiber.Map{"data": invalid},
+		})
+	}
+
+	subscribed, err := setSubscription(ctx, req.ChatId, req.UserId, true)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	message := "Already subscribed"
+	if subscribed {
+		message = "Subscribed"
+	}
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{
+		Status:  http.StatusOK,
+		Message: message,
+		Data:    &fiber.Map{"data": req},
+	})
+}
+
+func UnsubscribeChannel(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.ChannelSubscriptionReq
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Unable to parse JSON",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+	// the user acting is whoever is signed in, whatever the body says
+	req.UserId = authFrom(c).UserId
+
+	unsubscribed, err := setSubscription(ctx, req.ChatId, req.UserId, false)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+	if !unsubscribed {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{
+			Status:  http.StatusNotFound,
+			Message: "Not subscribed to this channel",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{
+		Status:  http.StatusOK,
+		Message: "Unsubscribed",
+		Data:    &fiber.Map{"data": req},
+	})
+}
+
+func AddChannelAdmin(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.AddChannelAdminReq
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Unable to parse JSON",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+	// the user acting is whoever is signed in, whatever the body says
+	req.UserId = authFrom(c).UserId
+
+	invalid, err := findInvalidUsers(ctx, []primitive.ObjectID{req.AdminId})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+	if len(invalid) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Some users are invalid",
+			Data:    &fiber.Map{"data": invalid},
+		})
+	}
+
+	// only an admin can promote someone else
+	filter := bson.D{{Key: "chatid", Value: req.ChatId}, {Key: "chattype", Value: models.ChatTypeChannel}, {Key: "users", Value: req.UserId}}
+	update := bson.D{{Key: "$addToSet", Value: bson.D{{Key: "users", Value: req.AdminId}}}}
+	if err := chatCollection.FindOneAndUpdate(ctx, filter, update).Err(); err != nil {
+		return c.Status(http.StatusForbidden).JSON(responses.UserResponse{
+			Status:  http.StatusForbidden,
+			Message: "Only channel admins can add admins",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{
+		Status:  http.StatusOK,
+		Message: "Admin Added",
+		Data:    &fiber.Map{"data": req},
+	})
+}
+
+// setSubscription subscribes or unsubscribes a user and keeps the channel's subscriber count
+// in step. It reports false when the subscription was already in the wanted state.
+func setSubscription(ctx context.Context, chatId primitive.ObjectID, userId primitive.ObjectID, subscribe bool) (bool, error) {
+	filter := bson.D{{Key: "chatid", Value: chatId}, {Key: "userid", Value: userId}, {Key: "subscribed", Value: !subscribe}}
+	if subscribe {
+		filter = bson.D{{Key: "chatid", Value: chatId}, {Key: "userid", Value: userId}, {Key: "subscribed", Value: bson.D{{Key: "$ne", Value: true}}}}
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "subscribed", Value: subscribe}}}}
+
+	result, err := membershipCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(subscribe))
+	if mongo.IsDuplicateKeyError(err) {
+		// the upsert lost to an existing subscribed membership
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if result.ModifiedCount == 0 && result.UpsertedCount == 0 {
+		return false, nil
+	}
+
+	change := 1
+	if !subscribe {
+		change = -1
+	}
+	_, err = chatCollection.UpdateOne(ctx, bson.D{{Key: "chatid", Value: chatId}}, bson.D{{Key: "$inc", Value: bson.D{{Key: "subscribercount", Value: change}}}})
+	return true, err
+}
+
+// subscribedChannelIds lists the channels a user reads as a subscriber
+func subscribedChannelIds(ctx context.Context, userId primitive.ObjectID) ([]primitive.ObjectID, error) {
+	cursor, err := membershipCollection.Find(ctx, bson.D{{Key: "userid", Value: userId}, {Key: "subscribed", Value: true}})
+	if err != nil {
+		return nil, err
+	}
+
+	var states []models.ChatMembership
+	if err := cursor.All(ctx, &states); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(states))
+	for _, state := range states {
+		ids = append(ids, state.ChatId)
+	}
+	return ids, nil
+}
+
+// findReadableChat loads a chat the user is one of the users of, or a channel they subscribe to
+func findReadableChat(ctx context.Context, chatId primitive.ObjectID, userId primitive.ObjectID) (*models.ChannelRes, error) {
+	var chat models.ChannelRes
+	if err := chatCollection.FindOne(ctx, bson.D{{Key: "chatid", Value: chatId}}).Decode(&chat); err != nil {
+		return nil, err
+	}
+
+	if canPost(&chat, userId) {
+		return &chat, nil
+	}
+
+	if chat.ChatType == models.ChatTypeChannel {
+		filter := bson.D{{Key: "chatid", Value: chatId}, {Key: "userid", Value: userId}, {Key: "subscribed", Value: true}}
+		if err := membershipCollection.FindOne(ctx, filter).Err(); err == nil {
+			return &chat, nil
+		} else if err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+	}
+
+	return nil, mongo.ErrNoDocuments
+}
+
+// canPost tells if a user may send messages to a chat, which in a channel only its admins can
+func canPost(chat *models.ChannelRes, userId primitive.ObjectID) bool {
+	for _, id := range chat.Users {
+		if id == userId {
+			return true
+		}
+	}
+	return false
+}
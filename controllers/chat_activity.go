@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// messages written by the socket app dont go through SendMessage, so their chats are caught up
+// by looking for new messages this often
+const activityPollInterval = 5 * time.Second
+
+// messages can be written with a timestamp a little older than when they land, so each look
+// goes back this far before the newest message already seen
+const activityOverlap = time.Minute
+
+// StartActivityWatcher keeps the latest activity of chats up to date with messages written
+// by other apps
+func StartActivityWatcher() {
+	go func() {
+		since, err := latestChatActivity()
+		if err != nil {
+			log.Print(err)
+		}
+		for {
+			newest, err := catchUpChatActivity(since)
+			if err != nil {
+				log.Print(err)
+			}
+			if newest > since {
+				since = newest
+			}
+			time.Sleep(activityPollInterval)
+		}
+	}()
+}
+
+// latestChatActivity is the newest activity already recorded on any chat
+func latestChatActivity() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var chat models.CreateChatRes
+	opts := options.FindOne().SetSort(bson.D{{Key: "lastactivityat", Value: -1}})
+	if err := chatCollection.FindOne(ctx, bson.D{}, opts).Decode(&chat); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return chat.LastActivityAt, nil
+}
+
+// catchUpChatActivity records the latest message of every chat written to since the time, and
+// returns the newest message time it saw
+func catchUpChatActivity(since int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cursor, err := messageCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "timestamp", Value: bson.D{{Key: "$gt", Value: since - activityOverlap.Milliseconds()}}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "timestamp", Value: 1}}}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$roomid"}, {Key: "message", Value: bson.D{{Key: "$last", Value: "$$ROOT"}}}}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var latest []struct {
+		Message models.Message `bson:"message"`
+	}
+	if err := cursor.All(ctx, &latest); err != nil {
+		return 0, err
+	}
+
+	var newest int64
+	for _, chat := range latest {
+		if err := touchChat(ctx, chat.Message); err != nil {
+			return newest, err
+		}
+		if chat.Message.Timestamp > newest {
+			newest = chat.Message.Timestamp
+		}
+	}
+	return newest, nil
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/achintya-7/go-fiber-chat/configs"
@@ -13,6 +15,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var chatCollection *mongo.Collection = configs.GetCollection(configs.DB, "chats")
@@ -33,33 +36,107 @@ func CreateChat(c *fiber.Ctx) error {
 		})
 	}
 
-	chatFilter := bson.D{{Key: "users", Value: bson.D{{Key: "$all", Value: bson.A{chat.UserId, chat.SecondUserId}}}}, {Key: "isgroup", Value: false}}
-	isChat := chatCollection.FindOne(ctx, chatFilter)
+	if chat.UserId == chat.SecondUserId && !allowSelfChat {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Cant create a chat with yourself",
+			Data:    &fiber.Map{},
+		})
+	}
+
+	blocked, err := blockedUnverified(ctx, chat.UserId, actionCreateChat)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+	if blocked {
+		return c.Status(http.StatusForbidden).JSON(responses.UserResponse{
+			Status:  http.StatusForbidden,
+			Message: "Verify your email to start chats",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	// a note to self is stored with a single member
+	users := dedupeUserIds([]primitive.ObjectID{chat.UserId, chat.SecondUserId})
 
-	// no document was found
-	if isChat.Err() != nil {
+	invalid, err := findInvalidUsers(ctx, users)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{},
+		})
+	}
+	if len(invalid) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Some users are invalid",
+			Data: &fiber.Map{
+				"data": invalid,
+			},
+		})
+	}
 
-		chatNew := models.CreateChatRes{
-			ChatId:          primitive.NewObjectID(),
-			IsGroup:         false,
-			Users:           []primitive.ObjectID{chat.UserId, chat.SecondUserId},
-			LatestMessage:   "",
-			LatestMessageId: "",
-			UserId:          chat.UserId,
-			ChatName:        "",
+	if len(users) > 1 {
+		blocked, err := isBlockedEither(ctx, chat.UserId, chat.SecondUserId)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+				Status:  http.StatusInternalServerError,
+				Message: err.Error(),
+				Data:    &fiber.Map{},
+			})
+		}
+		if blocked {
+			return c.Status(http.StatusForbidden).JSON(responses.UserResponse{
+				Status:  http.StatusForbidden,
+				Message: "Cant start a chat with this user",
+				Data:    &fiber.Map{},
+			})
 		}
 
-		result, err := chatCollection.InsertOne(ctx, chatNew)
+		allowed, err := acceptsDirectChatFrom(ctx, chat.SecondUserId, chat.UserId)
 		if err != nil {
 			return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
 				Status:  http.StatusInternalServerError,
 				Message: err.Error(),
-				Data: &fiber.Map{
-					"data": result,
-				},
+				Data:    &fiber.Map{},
 			})
 		}
+		if !allowed {
+			return c.Status(http.StatusForbidden).JSON(responses.UserResponse{
+				Status:  http.StatusForbidden,
+				Message: "This user only accepts chats from contacts",
+				Data:    &fiber.Map{},
+			})
+		}
+	}
+
+	chatNew := models.CreateChatRes{
+		ChatId:          primitive.NewObjectID(),
+		ChatType:        models.ChatTypeDirect,
+		Users:           users,
+		LatestMessage:   "",
+		LatestMessageId: "",
+		UserId:          chat.UserId,
+		ChatName:        "",
+		LastActivityAt:  time.Now().UnixMilli(),
+		PairKey:         models.DirectPairKey(users...),
+	}
+
+	created, err := upsertDirectChat(ctx, chatNew, &resChat)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{},
+		})
+	}
 
+	if created {
 		return c.Status(http.StatusOK).JSON(responses.UserResponse{
 			Status:  200,
 			Message: "Chat Room Created",
@@ -70,7 +147,6 @@ func CreateChat(c *fiber.Ctx) error {
 	}
 
 	// a chat was found
-	isChat.Decode(&resChat)
 	return c.Status(http.StatusOK).JSON(responses.UserResponse{
 		Status:  200,
 		Message: "A Chat Room already exist",
@@ -80,6 +156,25 @@ func CreateChat(c *fiber.Ctx) error {
 	})
 }
 
+// upsertDirectChat inserts chatNew unless a direct chat with the same pair key exists, in
+// which case that chat is decoded into existing. The unique pair key index makes this safe
+// when both users open the chat at the same time.
+func upsertDirectChat(ctx context.Context, chatNew models.CreateChatRes, existing *models.CreateChatRes) (bool, error) {
+	filter := bson.D{{Key: "pairkey", Value: chatNew.PairKey}}
+	update := bson.D{{Key: "$setOnInsert", Value: chatNew}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+
+	err := chatCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(existing)
+	if mongo.IsDuplicateKeyError(err) {
+		// a concurrent request inserted the chat first, so it can now be read back
+		err = chatCollection.FindOne(ctx, filter).Decode(existing)
+	}
+	if err == mongo.ErrNoDocuments {
+		return true, nil
+	}
+	return false, err
+}
+
 func AddToGroup(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -95,30 +190,136 @@ func AddToGroup(c *fiber.Ctx) error {
 				},
 			})
 	}
+	// group add settings are checked against whoever is signed in, whatever the body says
+	req.UserId = authFrom(c).UserId
 
-	filter := bson.D{{Key: "chatid", Value: req.ChatId}, {Key: "isgroup", Value: true}}
-	update := bson.D{
-		{
-			Key: "$addToSet",
-			Value: bson.D{
-				{
-					Key: "users",
-					Value: bson.D{
-						{
-							Key:   "$each",
-							Value: req.Users,
+	filter := bson.D{{Key: "chatid", Value: req.ChatId}, {Key: "chattype", Value: models.ChatTypeGroup}}
+
+	var group models.CreateGroupChatRes
+	if err := chatCollection.FindOne(ctx, filter).Decode(&group); err != nil {
+		return c.Status(http.StatusNotFound).JSON(
+			responses.UserResponse{
+				Status:  http.StatusNotFound,
+				Message: "Unable to find Group Chat",
+				Data: &fiber.Map{
+					"data": &fiber.Map{},
+				},
+			})
+	}
+
+	if len(dedupeUserIds([]primitive.ObjectID{req.UserId}, group.Users...)) > 0 {
+		return c.Status(http.StatusForbidden).JSON(
+			responses.UserResponse{
+				Status:  http.StatusForbidden,
+				Message: "Only members can add users to a group",
+				Data: &fiber.Map{
+					"data": &fiber.Map{},
+				},
+			})
+	}
+
+	// users already in the group are not added again
+	req.Users = dedupeUserIds(req.Users, group.Users...)
+	if len(req.Users) == 0 {
+		return c.Status(http.StatusBadRequest).JSON(
+			responses.UserResponse{
+				Status:  http.StatusBadRequest,
+				Message: "No new users to add",
+				Data: &fiber.Map{
+					"data": &fiber.Map{},
+				},
+			})
+	}
+
+	if len(group.Users)+len(req.Users) > maxGroupSize {
+		return c.Status(http.StatusBadRequest).JSON(
+			responses.UserResponse{
+				Status:  http.StatusBadRequest,
+				Message: fmt.Sprintf("A group can have at most %d users", maxGroupSize),
+				Data: &fiber.Map{
+					"data": &fiber.Map{},
+				},
+			})
+	}
+
+	invalid, err := findInvalidUsers(ctx, req.Users)
+	if err == nil {
+		var blocking []models.InvalidUser
+		blocking, err = findBlockingUsers(ctx, req.UserId, req.Users)
+		invalid = append(invalid, blocking...)
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(
+			responses.UserResponse{
+				Status:  http.StatusInternalServerError,
+				Message: err.Error(),
+				Data: &fiber.Map{
+					"data": &fiber.Map{},
+				},
+			})
+	}
+	if len(invalid) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(
+			responses.UserResponse{
+				Status:  http.StatusBadRequest,
+				Message: "Some users are invalid",
+				Data: &fiber.Map{
+					"data": invalid,
+				},
+			})
+	}
+
+	// users whose settings dont let the actor add them get an invite instead
+	add, invite, err := splitGroupAdds(ctx, req.UserId, req.Users)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(
+			responses.UserResponse{
+				Status:  http.StatusInternalServerError,
+				Message: err.Error(),
+				Data: &fiber.Map{
+					"data": &fiber.Map{},
+				},
+			})
+	}
+	req.Users = add
+
+	if len(req.Users) > 0 {
+		// the group must still have room when the update lands, so a concurrent add cant overflow it
+		filter = append(filter, hasRoomFor(len(req.Users)))
+		update := bson.D{
+			{
+				Key: "$addToSet",
+				Value: bson.D{
+					{
+						Key: "users",
+						Value: bson.D{
+							{
+								Key:   "$each",
+								Value: req.Users,
+							},
 						},
 					},
 				},
 			},
-		},
+		}
+
+		if err := chatCollection.FindOneAndUpdate(ctx, filter, update); err.Err() != nil {
+			return c.Status(http.StatusConflict).JSON(
+				responses.UserResponse{
+					Status:  http.StatusConflict,
+					Message: "Group Chat changed or is full, try again",
+					Data: &fiber.Map{
+						"data": &fiber.Map{},
+					},
+				})
+		}
 	}
 
-	if err := chatCollection.FindOneAndUpdate(ctx, filter, update); err.Err() != nil {
+	if err := sendGroupInvites(ctx, group.ChatId, group.ChatName, req.UserId, invite); err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(
 			responses.UserResponse{
 				Status:  http.StatusInternalServerError,
-				Message: "Unable to find Group Chat",
+				Message: err.Error(),
 				Data: &fiber.Map{
 					"data": &fiber.Map{},
 				},
@@ -130,7 +331,8 @@ func AddToGroup(c *fiber.Ctx) error {
 			Status:  200,
 			Message: "User Added",
 			Data: &fiber.Map{
-				"data": req,
+				"data":    req,
+				"invited": invite,
 			},
 		})
 }
@@ -151,7 +353,7 @@ func DeleteFromGroup(c *fiber.Ctx) error {
 			})
 	}
 
-	filter := bson.D{{Key: "chatid", Value: req.ChatId}, {Key: "isgroup", Value: true}}
+	filter := bson.D{{Key: "chatid", Value: req.ChatId}, {Key: "chattype", Value: models.ChatTypeGroup}}
 	update := bson.D{
 		{
 			Key: "$pull",
@@ -193,10 +395,89 @@ func GetAllMessages(c *fiber.Ctx) error {
 	chatId := c.Params("chatId")
 	objId, _ := primitive.ObjectIDFromHex(chatId)
 
-	var messages []models.Message
+	auth := authFrom(c)
+	if _, err := findReadableChat(ctx, objId, auth.UserId); err != nil || !auth.allowsChat(objId) {
+		return c.Status(http.StatusNotFound).JSON(
+			responses.UserResponse{
+				Status:  http.StatusNotFound,
+				Message: "Chat not found",
+				Data:    &fiber.Map{},
+			})
+	}
 
-	filter := bson.D{{Key: "roomid", Value: objId}}
-	cursor, err := messageCollection.Find(ctx, filter)
+	// authors' photos are shown according to their privacy settings towards the viewer
+	viewer, err := newPrivacyViewer(ctx, auth.UserId)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(
+			responses.UserResponse{
+				Status:  http.StatusInternalServerError,
+				Message: err.Error(),
+				Data:    &fiber.Map{},
+			})
+	}
+
+	var messages []models.MessageRes
+
+	cursor, err := messageCollection.Aggregate(ctx, mongo.Pipeline{
+		{
+			{
+				Key:   "$match",
+				Value: bson.D{{Key: "roomid", Value: objId}},
+			},
+		},
+		{
+			{
+				Key: "$lookup",
+				Value: bson.D{
+					{Key: "from", Value: "reactions"},
+					{Key: "localField", Value: "messageid"},
+					{Key: "foreignField", Value: "messageid"},
+					{Key: "as", Value: "reactions"},
+				},
+			},
+		},
+		{
+			{
+				Key: "$lookup",
+				Value: bson.D{
+					{Key: "from", Value: "users"},
+					{Key: "localField", Value: "userid"},
+					{Key: "foreignField", Value: "id"},
+					{Key: "as", Value: "author"},
+				},
+			},
+		},
+		{
+			{
+				Key: "$unwind",
+				Value: bson.D{
+					{Key: "path", Value: "$author"},
+					{Key: "preserveNullAndEmptyArrays", Value: true},
+				},
+			},
+		},
+		{
+			{
+				Key: "$project",
+				Value: bson.D{
+					{Key: "userid", Value: 1},
+					{Key: "roomid", Value: 1},
+					{Key: "content", Value: 1},
+					{Key: "contenttype", Value: 1},
+					{Key: "messageid", Value: 1},
+					{Key: "timestamp", Value: 1},
+					{Key: "reactions", Value: 1},
+					{Key: "author.id", Value: 1},
+					{Key: "author.name", Value: 1},
+					{Key: "author.handle", Value: 1},
+					{Key: "author.avatarurl", Value: 1},
+					{Key: "author.statustext", Value: 1},
+					{Key: "author.statusexpiresat", Value: 1},
+					{Key: "author.privacy", Value: 1},
+				},
+			},
+		},
+	})
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(
 			responses.UserResponse{
@@ -213,10 +494,14 @@ func GetAllMessages(c *fiber.Ctx) error {
 	defer cursor.Close(ctx)
 
 	for cursor.Next(ctx) {
-		var singleMessage models.Message
+		var singleMessage models.MessageRes
 		if err = cursor.Decode(&singleMessage); err != nil {
 			continue
 		}
+		if singleMessage.Author != nil {
+			singleMessage.Author.HideExpiredStatus()
+			viewer.userInfo(singleMessage.Author)
+		}
 		messages = append(messages, singleMessage)
 	}
 
@@ -232,47 +517,55 @@ func GetAllMessages(c *fiber.Ctx) error {
 
 func GetAllChats(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	userId := c.Params("userId")
 	defer cancel()
 
-	// var chats []models.CreateChatRes
+	// the chats and privacy are those of the signed in user
+	objId := authFrom(c).UserId
 
-	objId, _ := primitive.ObjectIDFromHex(userId)
+	// archived chats are only listed when asked for with ?archived=true
+	archived := c.Query("archived") == "true"
+	search := strings.TrimSpace(c.Query("q"))
 
-	showLoadedCursor, err := chatCollection.Aggregate(ctx, mongo.Pipeline{
-		{
-			{
-				Key:   "$match",
-				Value: bson.D{{Key: "users", Value: objId}},
-			},
-		},
-		{
-			{
-				Key: "$lookup",
-				Value: bson.D{
-					{Key: "from", Value: "users"},
-					{Key: "localField", Value: "users"},
-					{Key: "foreignField", Value: "id"},
-					{Key: "as", Value: "users"},
-				},
-			},
-		},
-		{
-			{
-				Key: "$project",
-				Value: bson.D{
-					{Key: "chatid", Value: 1},
-					{Key: "chatname", Value: 1},
-					{Key: "isgroup", Value: 1},
-					{Key: "latestmessage", Value: 1},
-					{Key: "latestmessageid", Value: 1},
-					{Key: "userid", Value: 1},
-					{Key: "users.id", Value: 1},
-					{Key: "users.name", Value: 1},
-				},
-			},
-		},
-	})
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit < 1 {
+		limit = defaultChatsLimit
+	}
+	if limit > maxChatsLimit {
+		limit = maxChatsLimit
+	}
+
+	after, err := parseChatCursor(c.Query("cursor"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(
+			responses.UserResponse{
+				Status:  http.StatusBadRequest,
+				Message: "Invalid cursor",
+				Data:    &fiber.Map{},
+			})
+	}
+
+	// channels are read through a subscription rather than being in the chat's users
+	channelIds, err := subscribedChannelIds(ctx, objId)
+	if err != nil {
+		return c.Status(400).JSON(
+			responses.UserResponse{
+				Status:  400,
+				Message: err.Error(),
+				Data:    &fiber.Map{},
+			})
+	}
+
+	viewer, err := newPrivacyViewer(ctx, objId)
+	if err != nil {
+		return c.Status(400).JSON(
+			responses.UserResponse{
+				Status:  400,
+				Message: err.Error(),
+				Data:    &fiber.Map{},
+			})
+	}
+
+	flags, err := loadChatFlags(ctx, objId)
 	if err != nil {
 		return c.Status(400).JSON(
 			responses.UserResponse{
@@ -282,8 +575,36 @@ func GetAllChats(c *fiber.Ctx) error {
 			})
 	}
 
-	var chatsLoaded []models.CreateChatRes2
-	if err = showLoadedCursor.All(ctx, &chatsLoaded); err != nil {
+	chatsLoaded := []models.CreateChatRes2{}
+	activitySort := bson.D{{Key: "lastactivityat", Value: -1}, {Key: "chatid", Value: -1}}
+
+	// pinned chats are few, so they all come on the first page ahead of the paginated inbox
+	if !archived && after == nil && len(flags.Pinned) > 0 {
+		pinnedMatch := bson.D{{Key: "chatid", Value: bson.D{{Key: "$in", Value: flags.Pinned}}}}
+
+		pinned, err := loadChats(ctx, chatListPipeline(objId, channelIds, pinnedMatch, activitySort, nil, search, 0))
+		if err != nil {
+			return c.Status(400).JSON(
+				responses.UserResponse{
+					Status:  400,
+					Message: err.Error(),
+					Data:    &fiber.Map{},
+				})
+		}
+		for i := range pinned {
+			fillChatState(&pinned[i], objId)
+		}
+		sortPinnedChats(pinned)
+		chatsLoaded = append(chatsLoaded, pinned...)
+	}
+
+	idMatch := bson.D{{Key: "chatid", Value: bson.D{{Key: "$nin", Value: append(flags.Pinned, flags.Archived...)}}}}
+	if archived {
+		idMatch = bson.D{{Key: "chatid", Value: bson.D{{Key: "$in", Value: flags.Archived}}}}
+	}
+
+	page, err := loadChats(ctx, chatListPipeline(objId, channelIds, idMatch, activitySort, after, search, limit+1))
+	if err != nil {
 		return c.Status(400).JSON(
 			responses.UserResponse{
 				Status:  400,
@@ -292,14 +613,23 @@ func GetAllChats(c *fiber.Ctx) error {
 			})
 	}
 
+	// one extra chat was fetched to know if another page exists
+	nextCursor := ""
+	if len(page) > limit {
+		page = page[:limit]
+		nextCursor = formatChatCursor(page[limit-1])
+	}
+	chatsLoaded = append(chatsLoaded, page...)
+
 	for i := 0; i < len(chatsLoaded); i++ {
 		// if the chat is not a group chat, rename the chat name to the other user's name
-		if !chatsLoaded[i].IsGroup {
-			if chatsLoaded[i].Users[0].Id == objId {
-				chatsLoaded[i].ChatName = chatsLoaded[i].Users[1].Name
-			} else {
-				chatsLoaded[i].ChatName = chatsLoaded[i].Users[0].Name
-			}
+		if chatsLoaded[i].ChatType == models.ChatTypeDirect {
+			chatsLoaded[i].ChatName = directChatName(&chatsLoaded[i], objId)
+		}
+		fillChatState(&chatsLoaded[i], objId)
+		for j := range chatsLoaded[i].Users {
+			chatsLoaded[i].Users[j].HideExpiredStatus()
+			viewer.userInfo(&chatsLoaded[i].Users[j])
 		}
 	}
 
@@ -310,7 +640,8 @@ func GetAllChats(c *fiber.Ctx) error {
 			Status:  200,
 			Message: messageRes,
 			Data: &fiber.Map{
-				"data": chatsLoaded,
+				"data":       chatsLoaded,
+				"nextCursor": nextCursor,
 			},
 		})
 }
@@ -330,7 +661,40 @@ func CreateGroupChat(c *fiber.Ctx) error {
 				},
 			})
 	}
+	// group add settings are checked against whoever is signed in, whatever the body says
+	req.UserId = authFrom(c).UserId
+
+	blocked, err := blockedUnverified(ctx, req.UserId, actionCreateGroup)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+	if blocked {
+		return c.Status(http.StatusForbidden).JSON(responses.UserResponse{
+			Status:  http.StatusForbidden,
+			Message: "Verify your email to create groups",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	if req.Visibility == "" {
+		req.Visibility = models.GroupPrivate
+	}
+	if req.Visibility != models.GroupPrivate && req.Visibility != models.GroupPublic {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Visibility must be private or public",
+			Data: &fiber.Map{
+				"data": &fiber.Map{},
+			},
+		})
+	}
 
+	// the creator is always a member, so drop them and any repeats from the invite list
+	req.Users = dedupeUserIds(req.Users, req.UserId)
 	if len(req.Users) < 1 {
 		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
 			Status:  http.StatusBadRequest,
@@ -343,14 +707,64 @@ func CreateGroupChat(c *fiber.Ctx) error {
 
 	req.Users = append(req.Users, req.UserId)
 
+	if len(req.Users) > maxGroupSize {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: fmt.Sprintf("A group can have at most %d users", maxGroupSize),
+			Data: &fiber.Map{
+				"data": &fiber.Map{},
+			},
+		})
+	}
+
+	invalid, err := findInvalidUsers(ctx, req.Users)
+	if err == nil {
+		var blocking []models.InvalidUser
+		blocking, err = findBlockingUsers(ctx, req.UserId, req.Users)
+		invalid = append(invalid, blocking...)
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data: &fiber.Map{
+				"data": &fiber.Map{},
+			},
+		})
+	}
+	if len(invalid) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Some users are invalid",
+			Data: &fiber.Map{
+				"data": invalid,
+			},
+		})
+	}
+
+	// users whose settings dont let the creator add them get an invite instead
+	add, invite, err := splitGroupAdds(ctx, req.UserId, req.Users)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data: &fiber.Map{
+				"data": &fiber.Map{},
+			},
+		})
+	}
+
 	chatNew := models.CreateGroupChatRes{
 		ChatId:          primitive.NewObjectID(),
-		IsGroup:         true,
-		Users:           req.Users,
+		ChatType:        models.ChatTypeGroup,
+		Users:           add,
 		UserId:          req.UserId,
 		LatestMessage:   "",
 		LatestMessageId: "",
 		ChatName:        req.ChatName,
+		LastActivityAt:  time.Now().UnixMilli(),
+		Description:     req.Description,
+		Visibility:      req.Visibility,
 	}
 
 	result, err := chatCollection.InsertOne(ctx, chatNew)
@@ -364,11 +778,22 @@ func CreateGroupChat(c *fiber.Ctx) error {
 		})
 	}
 
+	if err := sendGroupInvites(ctx, chatNew.ChatId, chatNew.ChatName, req.UserId, invite); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data: &fiber.Map{
+				"data": &fiber.Map{},
+			},
+		})
+	}
+
 	return c.Status(http.StatusOK).JSON(responses.UserResponse{
 		Status:  200,
 		Message: "Chat Room Created",
 		Data: &fiber.Map{
-			"data": chatNew,
+			"data":    chatNew,
+			"invited": invite,
 		},
 	})
 
@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/achintya-7/go-fiber-chat/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const defaultChatsLimit = 20
+const maxChatsLimit = 100
+
+// chatCursor points at the last chat of a page, ordered by latest activity
+type chatCursor struct {
+	LastActivityAt int64
+	ChatId         primitive.ObjectID
+}
+
+// cursors are sent to clients as "<lastActivityAt>_<chatId>"
+func formatChatCursor(chat models.CreateChatRes2) string {
+	return fmt.Sprintf("%d_%s", chat.LastActivityAt, chat.ChatId.Hex())
+}
+
+func parseChatCursor(cursor string) (*chatCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	parts := strings.SplitN(cursor, "_", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("malformed cursor")
+	}
+
+	lastActivityAt, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	chatId, err := primitive.ObjectIDFromHex(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return &chatCursor{LastActivityAt: lastActivityAt, ChatId: chatId}, nil
+}
+
+// chatFlags are the ids of a user's pinned and archived chats. They are few, so the chat list
+// can be filtered on them before it is sorted and limited, instead of joining every membership.
+type chatFlags struct {
+	Pinned   []primitive.ObjectID
+	Archived []primitive.ObjectID
+}
+
+func loadChatFlags(ctx context.Context, userId primitive.ObjectID) (*chatFlags, error) {
+	filter := bson.D{
+		{Key: "userid", Value: userId},
+		{Key: "$or", Value: bson.A{bson.D{{Key: "pinned", Value: true}}, bson.D{{Key: "archived", Value: true}}}},
+	}
+	cursor, err := membershipCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var states []models.ChatMembership
+	if err := cursor.All(ctx, &states); err != nil {
+		return nil, err
+	}
+
+	flags := &chatFlags{Pinned: []primitive.ObjectID{}, Archived: []primitive.ObjectID{}}
+	for _, state := range states {
+		// archiving a pinned chat moves it out of the pinned list
+		if state.Archived {
+			flags.Archived = append(flags.Archived, state.ChatId)
+		} else if state.Pinned {
+			flags.Pinned = append(flags.Pinned, state.ChatId)
+		}
+	}
+	return flags, nil
+}
+
+// chatListPipeline lists a user's chats and subscribed channels whose ids match idMatch in sort order,
+// starting after the cursor when one is given. A search matches the chat name or the name of another member.
+func chatListPipeline(userId primitive.ObjectID, channelIds []primitive.ObjectID, idMatch bson.D, sort bson.D, after *chatCursor, search string, limit int) mongo.Pipeline {
+	chatMatch := bson.D{{Key: "users", Value: userId}}
+	if len(channelIds) > 0 {
+		chatMatch = bson.D{{Key: "$or", Value: bson.A{
+			chatMatch,
+			bson.D{{Key: "chatid", Value: bson.D{{Key: "$in", Value: channelIds}}}},
+		}}}
+	}
+	chatMatch = append(chatMatch, idMatch...)
+
+	pipeline := mongo.Pipeline{
+		{
+			{
+				Key:   "$match",
+				Value: chatMatch,
+			},
+		},
+	}
+
+	if after != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "lastactivityat", Value: bson.D{{Key: "$lt", Value: after.LastActivityAt}}}},
+			bson.D{{Key: "lastactivityat", Value: after.LastActivityAt}, {Key: "chatid", Value: bson.D{{Key: "$lt", Value: after.ChatId}}}},
+		}}}}})
+	}
+
+	lookup := bson.D{
+		{
+			Key: "$lookup",
+			Value: bson.D{
+				{Key: "from", Value: "users"},
+				{Key: "localField", Value: "users"},
+				{Key: "foreignField", Value: "id"},
+				{Key: "as", Value: "users"},
+			},
+		},
+	}
+
+	pipeline = append(pipeline, bson.D{{Key: "$sort", Value: sort}})
+
+	// members only have to be looked up before the limit when the search filters on their names,
+	// otherwise only the chats of this page are joined. Settings are always joined after the limit.
+	if search != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(search), Options: "i"}
+		pipeline = append(pipeline, lookup, bson.D{{Key: "$match", Value: bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "chatname", Value: pattern}},
+			bson.D{{Key: "users", Value: bson.D{{Key: "$elemMatch", Value: bson.D{
+				{Key: "id", Value: bson.D{{Key: "$ne", Value: userId}}},
+				{Key: "name", Value: pattern},
+			}}}}},
+		}}}}})
+	}
+
+	if limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: limit}})
+	}
+
+	if search == "" {
+		pipeline = append(pipeline, lookup)
+	}
+	pipeline = append(pipeline, chatStateStages(userId)...)
+
+	pipeline = append(pipeline, bson.D{
+		{
+			Key: "$project",
+			Value: bson.D{
+				{Key: "chatid", Value: 1},
+				{Key: "chatname", Value: 1},
+				{Key: "chattype", Value: 1},
+				{Key: "latestmessage", Value: 1},
+				{Key: "latestmessageid", Value: 1},
+				{Key: "lastactivityat", Value: 1},
+				{Key: "subscribercount", Value: 1},
+				{Key: "deletedusers", Value: 1},
+				{Key: "description", Value: 1},
+				{Key: "visibility", Value: 1},
+				{Key: "userid", Value: 1},
+				{Key: "users.id", Value: 1},
+				{Key: "users.name", Value: 1},
+				{Key: "users.handle", Value: 1},
+				{Key: "users.avatarurl", Value: 1},
+				{Key: "users.statustext", Value: 1},
+				{Key: "users.statusexpiresat", Value: 1},
+				{Key: "users.privacy", Value: 1},
+				{Key: "state", Value: 1},
+			},
+		},
+	})
+
+	return pipeline
+}
+
+// sortPinnedChats puts pinned chats in the order the user pinned them, keeping the activity
+// order between chats with the same pin order
+func sortPinnedChats(chats []models.CreateChatRes2) {
+	sort.SliceStable(chats, func(i, j int) bool {
+		return chats[i].State.PinOrder < chats[j].State.PinOrder
+	})
+}
+
+func loadChats(ctx context.Context, pipeline mongo.Pipeline) ([]models.CreateChatRes2, error) {
+	cursor, err := chatCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	chats := []models.CreateChatRes2{}
+	if err = cursor.All(ctx, &chats); err != nil {
+		return nil, err
+	}
+	return chats, nil
+}
@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/responses"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var membershipCollection *mongo.Collection = configs.GetCollection(configs.DB, "memberships")
+
+// UpdateChatState saves the signed in user's settings for one of their chats
+func UpdateChatState(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId := authFrom(c).UserId
+	chatId, _ := primitive.ObjectIDFromHex(c.Params("chatId"))
+
+	var req models.UpdateChatStateReq
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Unable to parse JSON",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	// only members and subscribers of a chat can keep settings for it
+	if _, err := findReadableChat(ctx, chatId, userId); err != nil {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{
+			Status:  http.StatusNotFound,
+			Message: "Chat not found",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	set := bson.D{}
+	if req.Archived != nil {
+		set = append(set, bson.E{Key: "archived", Value: *req.Archived})
+	}
+	if req.Muted != nil {
+		set = append(set, bson.E{Key: "muted", Value: *req.Muted})
+		if !*req.Muted {
+			set = append(set, bson.E{Key: "muteduntil", Value: int64(0)})
+		}
+	}
+	if req.MutedUntil != nil && (req.Muted == nil || *req.Muted) {
+		set = append(set, bson.E{Key: "muteduntil", Value: *req.MutedUntil})
+	}
+	if req.Pinned != nil {
+		set = append(set, bson.E{Key: "pinned", Value: *req.Pinned})
+		if !*req.Pinned {
+			set = append(set, bson.E{Key: "pinorder", Value: 0})
+		} else if req.PinOrder == nil {
+			// newly pinned chats go after the ones already pinned
+			pinned, err := membershipCollection.CountDocuments(ctx, bson.D{{Key: "userid", Value: userId}, {Key: "pinned", Value: true}})
+			if err != nil {
+				return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+					Status:  http.StatusInternalServerError,
+					Message: err.Error(),
+					Data:    &fiber.Map{"data": &fiber.Map{}},
+				})
+			}
+			set = append(set, bson.E{Key: "pinorder", Value: int(pinned)})
+		}
+	}
+	if req.PinOrder != nil && (req.Pinned == nil || *req.Pinned) {
+		set = append(set, bson.E{Key: "pinorder", Value: *req.PinOrder})
+	}
+	if req.MarkedUnread != nil {
+		set = append(set, bson.E{Key: "markedunread", Value: *req.MarkedUnread})
+	}
+
+	if len(set) == 0 {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Nothing to update",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	filter := bson.D{{Key: "chatid", Value: chatId}, {Key: "userid", Value: userId}}
+	update := bson.D{{Key: "$set", Value: set}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var state models.ChatMembership
+	if err := membershipCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&state); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{
+		Status:  http.StatusOK,
+		Message: "Chat state updated",
+		Data:    &fiber.Map{"data": state},
+	})
+}
+
+// chatStateStages joins the requesting user's membership onto each chat as "state"
+func chatStateStages(userId primitive.ObjectID) mongo.Pipeline {
+	return mongo.Pipeline{
+		{
+			{
+				Key: "$lookup",
+				Value: bson.D{
+					{Key: "from", Value: "memberships"},
+					{Key: "let", Value: bson.D{{Key: "chatid", Value: "$chatid"}}},
+					{Key: "pipeline", Value: bson.A{
+						bson.D{{Key: "$match", Value: bson.D{
+							{Key: "$expr", Value: bson.D{{Key: "$eq", Value: bson.A{"$chatid", "$$chatid"}}}},
+							{Key: "userid", Value: userId},
+						}}},
+					}},
+					{Key: "as", Value: "state"},
+				},
+			},
+		},
+		{
+			{
+				Key: "$unwind",
+				Value: bson.D{
+					{Key: "path", Value: "$state"},
+					{Key: "preserveNullAndEmptyArrays", Value: true},
+				},
+			},
+		},
+	}
+}
+
+// fillChatState gives chats without saved settings the defaults and clears expired mutes
+func fillChatState(chat *models.CreateChatRes2, userId primitive.ObjectID) {
+	if chat.State == nil {
+		chat.State = &models.ChatMembership{ChatId: chat.ChatId, UserId: userId}
+	}
+	if chat.State.Muted && chat.State.MutedUntil != 0 && chat.State.MutedUntil <= time.Now().UnixMilli() {
+		chat.State.Muted = false
+		chat.State.MutedUntil = 0
+	}
+}
@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+	"github.com/achintya-7/go-fiber-chat/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maximum number of members a group chat can hold, including its creator
+var maxGroupSize = configs.GetEnvInt("MAX_GROUP_SIZE", 256)
+
+// whether a user may open a direct chat with themselves to keep notes
+var allowSelfChat = configs.GetEnvBool("ALLOW_SELF_CHAT", false)
+
+// dedupeUserIds drops repeated ids and any id in exclude, keeping the original order
+func dedupeUserIds(ids []primitive.ObjectID, exclude ...primitive.ObjectID) []primitive.ObjectID {
+	seen := make(map[primitive.ObjectID]bool, len(ids)+len(exclude))
+	for _, id := range exclude {
+		seen[id] = true
+	}
+
+	unique := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		unique = append(unique, id)
+	}
+	return unique
+}
+
+// findInvalidUsers returns an entry for every id that does not belong to an existing user
+func findInvalidUsers(ctx context.Context, ids []primitive.ObjectID) ([]models.InvalidUser, error) {
+	invalid := []models.InvalidUser{}
+	if len(ids) == 0 {
+		return invalid, nil
+	}
+
+	opts := options.Find().SetProjection(bson.D{{Key: "id", Value: 1}})
+	filter := bson.D{{Key: "id", Value: bson.D{{Key: "$in", Value: ids}}}, {Key: "deleting", Value: bson.D{{Key: "$ne", Value: true}}}}
+	cursor, err := userCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	found := make(map[primitive.ObjectID]bool, len(ids))
+	for cursor.Next(ctx) {
+		var user models.UserInfo
+		if err := cursor.Decode(&user); err != nil {
+			return nil, err
+		}
+		found[user.Id] = true
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		if id.IsZero() {
+			invalid = append(invalid, models.InvalidUser{UserId: id, Reason: "missing user id"})
+		} else if !found[id] {
+			invalid = append(invalid, models.InvalidUser{UserId: id, Reason: "user does not exist"})
+		}
+	}
+	return invalid, nil
+}
+
+// hasRoomFor matches groups that can take count more users without going over the size limit.
+// Checking it in the update filter keeps concurrent adds from overflowing a group.
+func hasRoomFor(count int) bson.E {
+	return bson.E{Key: fmt.Sprintf("users.%d", maxGroupSize-count), Value: bson.D{{Key: "$exists", Value: false}}}
+}
+
+// directChatName names a direct chat after the other member, or the user themselves for a note to self.
+// A member who deleted their account is put back into the users as a deleted user.
+func directChatName(chat *models.CreateChatRes2, userId primitive.ObjectID) string {
+	for _, deletedId := range chat.DeletedUsers {
+		if deletedId != userId {
+			chat.Users = append(chat.Users, models.UserInfo{Id: deletedId, Name: deletedUserName})
+			return deletedUserName
+		}
+	}
+
+	for _, user := range chat.Users {
+		if user.Id != userId {
+			return user.Name
+		}
+	}
+	if len(chat.Users) > 0 {
+		return chat.Users[0].Name
+	}
+	return ""
+}
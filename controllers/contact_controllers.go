@@ -0,0 +1,299 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/responses"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var contactCollection *mongo.Collection = configs.GetCollection(configs.DB, "contacts")
+
+// users seen within this window are shown as online
+var presenceWindow = time.Duration(configs.GetEnvInt("PRESENCE_WINDOW_SECONDS", 300)) * time.Second
+
+func SendContactRequest(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, _ := primitive.ObjectIDFromHex(c.Params("userId"))
+
+	var req models.ContactReq
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if req.TargetId == userId {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "You cant add yourself"}})
+	}
+
+	invalid, err := findInvalidUsers(ctx, []primitive.ObjectID{userId, req.TargetId})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if len(invalid) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "Some users are invalid", Data: &fiber.Map{"data": invalid}})
+	}
+
+	blocked, err := isBlockedEither(ctx, userId, req.TargetId)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if blocked {
+		return c.Status(http.StatusForbidden).JSON(responses.UserResponse{Status: http.StatusForbidden, Message: "error", Data: &fiber.Map{"data": "Cant add this user"}})
+	}
+
+	pairKey := models.DirectPairKey(userId, req.TargetId)
+
+	var existing models.ContactRequest
+	err = contactCollection.FindOne(ctx, bson.D{{Key: "pairkey", Value: pairKey}}).Decode(&existing)
+	if err == nil {
+		// asking someone who already asked you accepts their request
+		if existing.Status == models.ContactPending && existing.ToId == userId {
+			accepted, err := respondToContactRequest(ctx, existing.RequestId, userId, true)
+			if err != nil {
+				return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+			}
+			return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": accepted}})
+		}
+		return c.Status(http.StatusConflict).JSON(responses.UserResponse{Status: http.StatusConflict, Message: "error", Data: &fiber.Map{"data": existing}})
+	}
+	if err != mongo.ErrNoDocuments {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	request := models.ContactRequest{
+		RequestId: primitive.NewObjectID(),
+		FromId:    userId,
+		ToId:      req.TargetId,
+		PairKey:   pairKey,
+		Status:    models.ContactPending,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	if _, err := contactCollection.InsertOne(ctx, request); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(http.StatusConflict).JSON(responses.UserResponse{Status: http.StatusConflict, Message: "error", Data: &fiber.Map{"data": "A request between these users already exists"}})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusCreated).JSON(responses.UserResponse{Status: http.StatusCreated, Message: "success", Data: &fiber.Map{"data": request}})
+}
+
+func GetContactRequests(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, _ := primitive.ObjectIDFromHex(c.Params("userId"))
+
+	filter := bson.D{{Key: "toid", Value: userId}, {Key: "status", Value: models.ContactPending}}
+	cursor, err := contactCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "createdat", Value: -1}}))
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	requests := []models.ContactRequest{}
+	if err := cursor.All(ctx, &requests); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": requests}})
+}
+
+func AcceptContactRequest(c *fiber.Ctx) error {
+	return answerContactRequest(c, true)
+}
+
+func DeclineContactRequest(c *fiber.Ctx) error {
+	return answerContactRequest(c, false)
+}
+
+func answerContactRequest(c *fiber.Ctx, accept bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, _ := primitive.ObjectIDFromHex(c.Params("userId"))
+	requestId, _ := primitive.ObjectIDFromHex(c.Params("requestId"))
+
+	request, err := respondToContactRequest(ctx, requestId, userId, accept)
+	if err == mongo.ErrNoDocuments {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "Contact request not found"}})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": request}})
+}
+
+func GetContacts(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, _ := primitive.ObjectIDFromHex(c.Params("userId"))
+
+	ids, err := contactIds(ctx, userId)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	contacts := []models.ContactRes{}
+	if len(ids) > 0 {
+		cursor, err := userCollection.Find(ctx, bson.D{{Key: "id", Value: bson.D{{Key: "$in", Value: ids}}}}, options.Find().SetSort(bson.D{{Key: "searchname", Value: 1}}))
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+		}
+
+		var users []models.User
+		if err := cursor.All(ctx, &users); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+		}
+
+		viewer := privacyViewerWith(userId, ids)
+		for _, user := range users {
+			contact := toContact(user)
+			viewer.contact(&contact, user.Privacy)
+			contacts = append(contacts, contact)
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": contacts}})
+}
+
+func RemoveContact(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, _ := primitive.ObjectIDFromHex(c.Params("userId"))
+	contactId, _ := primitive.ObjectIDFromHex(c.Params("contactId"))
+
+	filter := bson.D{{Key: "pairkey", Value: models.DirectPairKey(userId, contactId)}, {Key: "status", Value: models.ContactAccepted}}
+	result, err := contactCollection.DeleteOne(ctx, filter)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if result.DeletedCount < 1 {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "Contact not found"}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "Contact removed"}})
+}
+
+// Heartbeat keeps a user shown as online while their client is open
+func Heartbeat(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, _ := primitive.ObjectIDFromHex(c.Params("userId"))
+
+	if err := touchPresence(ctx, userId); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": &fiber.Map{}}})
+}
+
+// respondToContactRequest accepts or declines a pending request sent to the user.
+// A declined request is deleted so it can be sent again.
+func respondToContactRequest(ctx context.Context, requestId primitive.ObjectID, userId primitive.ObjectID, accept bool) (*models.ContactRequest, error) {
+	filter := bson.D{{Key: "requestid", Value: requestId}, {Key: "toid", Value: userId}, {Key: "status", Value: models.ContactPending}}
+
+	var request models.ContactRequest
+	if !accept {
+		if err := contactCollection.FindOneAndDelete(ctx, filter).Decode(&request); err != nil {
+			return nil, err
+		}
+		return &request, nil
+	}
+
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "status", Value: models.ContactAccepted},
+		{Key: "respondedat", Value: time.Now().UnixMilli()},
+	}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := contactCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// contactIds lists the users a user has accepted as contacts, in either direction
+func contactIds(ctx context.Context, userId primitive.ObjectID) ([]primitive.ObjectID, error) {
+	cursor, err := contactCollection.Find(ctx, bson.D{
+		{Key: "status", Value: models.ContactAccepted},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "fromid", Value: userId}},
+			bson.D{{Key: "toid", Value: userId}},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var requests []models.ContactRequest
+	if err := cursor.All(ctx, &requests); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(requests))
+	for _, request := range requests {
+		if request.FromId == userId {
+			ids = append(ids, request.ToId)
+		} else {
+			ids = append(ids, request.FromId)
+		}
+	}
+	return ids, nil
+}
+
+// areContacts tells if two users accepted each other as contacts
+func areContacts(ctx context.Context, userId primitive.ObjectID, otherId primitive.ObjectID) (bool, error) {
+	filter := bson.D{{Key: "pairkey", Value: models.DirectPairKey(userId, otherId)}, {Key: "status", Value: models.ContactAccepted}}
+	count, err := contactCollection.CountDocuments(ctx, filter)
+	return count > 0, err
+}
+
+// touchPresence records that the user was just active
+func touchPresence(ctx context.Context, userId primitive.ObjectID) error {
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "lastseenat", Value: time.Now().UnixMilli()}}}}
+	_, err := userCollection.UpdateOne(ctx, bson.D{{Key: "id", Value: userId}}, update)
+	return err
+}
+
+func toContact(user models.User) models.ContactRes {
+	info := models.UserInfo{
+		Id:              user.Id,
+		Name:            user.Name,
+		Handle:          user.Handle,
+		AvatarUrl:       user.AvatarUrl,
+		StatusText:      user.StatusText,
+		StatusExpiresAt: user.StatusExpiresAt,
+	}
+	info.HideExpiredStatus()
+
+	return models.ContactRes{
+		UserInfo:   info,
+		Online:     time.Since(time.UnixMilli(user.LastSeenAt)) < presenceWindow,
+		LastSeenAt: user.LastSeenAt,
+	}
+}
+
+// acceptsDirectChatFrom tells if the recipient lets the sender open a direct chat with them
+func acceptsDirectChatFrom(ctx context.Context, recipientId primitive.ObjectID, senderId primitive.ObjectID) (bool, error) {
+	var recipient models.User
+	if err := userCollection.FindOne(ctx, bson.D{{Key: "id", Value: recipientId}}).Decode(&recipient); err != nil {
+		return false, err
+	}
+	if !recipient.DmsFromContactsOnly {
+		return true, nil
+	}
+	return areContacts(ctx, recipientId, senderId)
+}
@@ -0,0 +1,347 @@
+package controllers
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+	"github.com/achintya-7/go-fiber-chat/mailer"
+	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/responses"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ExportDir is where finished exports are kept until they expire. It is never served
+// statically, exports are only handed out with their download token.
+var ExportDir = exportDir()
+
+func exportDir() string {
+	if dir := configs.GetEnv("EXPORT_DIR"); dir != "" {
+		return dir
+	}
+	return "exports"
+}
+
+// how long an export can be downloaded once it is ready
+var exportTTL = time.Duration(configs.GetEnvInt("EXPORT_TTL_HOURS", 48)) * time.Hour
+
+// exports a user can ask for in a day, each one is costly to build and mails them
+const maxExportsPerDay = 3
+
+// exportUserSteps build a zip of everything stored about a user and send them a link to it
+var exportUserSteps = []jobStep{
+	writeExportArchive,
+	notifyExportReady,
+}
+
+func exportPath(jobId primitive.ObjectID) string {
+	return filepath.Join(ExportDir, jobId.Hex()+".zip")
+}
+
+// writeExportArchive writes the zip under a temporary name and renames it when complete,
+// so a retried step never leaves half an archive behind
+func writeExportArchive(ctx context.Context, job *models.Job) error {
+	var user models.User
+	if err := userCollection.FindOne(ctx, bson.D{{Key: "id", Value: job.UserId}}).Decode(&user); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(ExportDir, 0o750); err != nil {
+		return err
+	}
+	tmp := exportPath(job.JobId) + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	archive := zip.NewWriter(file)
+	if err := writeExportFiles(ctx, archive, &user); err != nil {
+		file.Close()
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, exportPath(job.JobId))
+}
+
+func writeExportFiles(ctx context.Context, archive *zip.Writer, user *models.User) error {
+	profile := fiber.Map{
+		"user":         models.ToSelfUser(*user),
+		"pendingEmail": user.PendingEmail,
+		"lastSeenAt":   user.LastSeenAt,
+	}
+	if err := writeExportJSON(archive, "profile.json", profile); err != nil {
+		return err
+	}
+
+	userFilter := bson.D{{Key: "userid", Value: user.Id}}
+	lists := []struct {
+		name       string
+		collection *mongo.Collection
+		filter     bson.D
+		item       func() interface{}
+	}{
+		{"chats.json", chatCollection, bson.D{{Key: "users", Value: user.Id}}, func() interface{} { return &models.ChannelRes{} }},
+		{"memberships.json", membershipCollection, userFilter, func() interface{} { return &models.ChatMembership{} }},
+		{"messages.json", messageCollection, userFilter, func() interface{} { return &models.Message{} }},
+		{"reactions.json", reactionCollection, userFilter, func() interface{} { return &models.Reaction{} }},
+		{"sessions.json", sessionCollection, userFilter, func() interface{} { return &models.Session{} }},
+		{"identities.json", identityCollection, userFilter, func() interface{} { return &models.Identity{} }},
+		{"passkeys.json", passkeyCollection, userFilter, func() interface{} { return &models.Passkey{} }},
+		{"audit_logs.json", auditCollection, userFilter, func() interface{} { return &models.AuditLog{} }},
+		{"contacts.json", contactCollection, bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "fromid", Value: user.Id}},
+			bson.D{{Key: "toid", Value: user.Id}},
+		}}}, func() interface{} { return &models.ContactRequest{} }},
+		{"group_invites.json", groupInviteCollection, bson.D{{Key: "toid", Value: user.Id}}, func() interface{} { return &models.GroupInvite{} }},
+		{"blocks.json", blockCollection, bson.D{{Key: "blockerid", Value: user.Id}}, func() interface{} { return &models.Block{} }},
+	}
+	for _, list := range lists {
+		cursor, err := list.collection.Find(ctx, list.filter)
+		if err != nil {
+			return err
+		}
+		if err := writeExportList(ctx, archive, list.name, cursor, list.item); err != nil {
+			return err
+		}
+	}
+
+	return writeExportAttachments(ctx, archive, user)
+}
+
+func writeExportJSON(archive *zip.Writer, name string, value interface{}) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// writeExportList streams the documents of a cursor into a JSON array, so large message
+// histories are never held in memory at once
+func writeExportList(ctx context.Context, archive *zip.Writer, name string, cursor *mongo.Cursor, item func() interface{}) error {
+	defer cursor.Close(ctx)
+
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	for first := true; cursor.Next(ctx); first = false {
+		doc := item()
+		if err := cursor.Decode(doc); err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(encoded); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]\n")
+	return err
+}
+
+// writeExportAttachments copies the user's avatar and the uploaded files they sent into
+// attachments/. Files that are gone from the upload directory are skipped.
+func writeExportAttachments(ctx context.Context, archive *zip.Writer, user *models.User) error {
+	urls := []string{}
+	if user.AvatarUrl != "" {
+		urls = append(urls, user.AvatarUrl)
+	}
+
+	filter := bson.D{
+		{Key: "userid", Value: user.Id},
+		{Key: "content", Value: bson.D{{Key: "$regex", Value: "^/uploads/"}}},
+	}
+	cursor, err := messageCollection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return err
+	}
+	for _, message := range messages {
+		urls = append(urls, message.Content)
+	}
+
+	for _, url := range urls {
+		name := path.Clean(strings.TrimPrefix(url, "/uploads/"))
+		if strings.HasPrefix(name, "..") {
+			continue
+		}
+		src, err := os.Open(filepath.Join(UploadDir, filepath.FromSlash(name)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		w, err := archive.Create("attachments/" + name)
+		if err == nil {
+			_, err = io.Copy(w, src)
+		}
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyExportReady gives the export a fresh download token and mails it to the user
+func notifyExportReady(ctx context.Context, job *models.Job) error {
+	var user models.User
+	if err := userCollection.FindOne(ctx, bson.D{{Key: "id", Value: job.UserId}}).Decode(&user); err != nil {
+		return err
+	}
+
+	token, err := models.NewToken()
+	if err != nil {
+		return err
+	}
+	job.TokenHash = models.HashToken(token)
+	job.ExpiresAt = time.Now().Add(exportTTL).UnixMilli()
+	if err := saveJob(ctx, job, bson.D{{Key: "tokenhash", Value: job.TokenHash}, {Key: "expiresat", Value: job.ExpiresAt}}); err != nil {
+		return err
+	}
+
+	link := "/exports/" + job.JobId.Hex() + "?token=" + token
+	body := "Your data export is ready. Download it from " + link + " before " + time.UnixMilli(job.ExpiresAt).UTC().Format(time.RFC1123) + "."
+	return mailer.Default.Send(user.Email, "Your data export is ready", body)
+}
+
+// removeExpiredExports deletes the archives of exports that can no longer be downloaded
+func removeExpiredExports() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "type", Value: models.JobExportUser},
+		{Key: "tokenhash", Value: bson.D{{Key: "$gt", Value: ""}}},
+		{Key: "expiresat", Value: bson.D{{Key: "$lt", Value: time.Now().UnixMilli()}}},
+	}
+	cursor, err := jobCollection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var jobs []models.Job
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if err := os.Remove(exportPath(job.JobId)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := saveJob(ctx, &job, bson.D{{Key: "tokenhash", Value: ""}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startExportSweeper removes expired exports once an hour
+func startExportSweeper() {
+	go func() {
+		for {
+			if err := removeExpiredExports(); err != nil {
+				log.Print(err)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+// ExportUserData queues an export of the user's data, a few times a day at most
+func ExportUserData(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	userId := c.Params("userId")
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(userId)
+
+	count, err := userCollection.CountDocuments(ctx, bson.D{{Key: "id", Value: objId}, {Key: "deleting", Value: bson.D{{Key: "$ne", Value: true}}}})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if count == 0 {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "User with specified ID not found!"}})
+	}
+
+	dayAgo := time.Now().Add(-24 * time.Hour).UnixMilli()
+	recent, err := jobCollection.CountDocuments(ctx, bson.D{{Key: "type", Value: models.JobExportUser}, {Key: "userid", Value: objId}, {Key: "createdat", Value: bson.D{{Key: "$gt", Value: dayAgo}}}})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if recent >= maxExportsPerDay {
+		return c.Status(http.StatusTooManyRequests).JSON(responses.UserResponse{Status: http.StatusTooManyRequests, Message: "error", Data: &fiber.Map{"data": "Too many exports, try again tomorrow"}})
+	}
+
+	job, err := enqueueJob(ctx, models.JobExportUser, objId)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusAccepted).JSON(
+		responses.UserResponse{Status: http.StatusAccepted, Message: "Export is being prepared", Data: &fiber.Map{"data": job}},
+	)
+}
+
+// DownloadExport hands out a finished export to whoever holds its token, until it expires
+func DownloadExport(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	jobId, _ := primitive.ObjectIDFromHex(c.Params("jobId"))
+	token := c.Query("token")
+
+	filter := bson.D{
+		{Key: "jobid", Value: jobId},
+		{Key: "type", Value: models.JobExportUser},
+		{Key: "status", Value: models.JobDone},
+		{Key: "tokenhash", Value: models.HashToken(token)},
+		{Key: "expiresat", Value: bson.D{{Key: "$gt", Value: time.Now().UnixMilli()}}},
+	}
+	var job models.Job
+	if token == "" || jobCollection.FindOne(ctx, filter).Decode(&job) != nil {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "Export not found or expired"}})
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Download(exportPath(job.JobId), "export-"+time.UnixMilli(job.CreatedAt).UTC().Format("2006-01-02")+".zip")
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var emailRequestCollection *mongo.Collection = configs.GetCollection(configs.DB, "email_requests")
+
+// emailLimit caps how often an email can be asked for, per address and per IP address. Asks
+// are counted whether or not an account uses the address, so being limited doesnt tell.
+type emailLimit struct {
+	kind string
+	// an address can be sent another email after this long
+	cooldown time.Duration
+	// emails an address can be sent, and an IP can ask for, in an hour
+	perHour   int64
+	perIPHour int64
+}
+
+var passwordResetLimit = emailLimit{kind: "password_reset", cooldown: time.Minute, perHour: 5, perIPHour: 20}
+
+// allow records an ask for an email to the address, and reports false when it goes over the limit
+func (limit emailLimit) allow(ctx context.Context, email string, ip string) (bool, error) {
+	now := time.Now()
+	hourAgo := now.Add(-time.Hour).UnixMilli()
+
+	if _, err := emailRequestCollection.DeleteMany(ctx, bson.D{{Key: "createdat", Value: bson.D{{Key: "$lt", Value: hourAgo}}}}); err != nil {
+		return false, err
+	}
+
+	count := func(key string, value string, since int64) (int64, error) {
+		return emailRequestCollection.CountDocuments(ctx, bson.D{{Key: "kind", Value: limit.kind}, {Key: key, Value: value}, {Key: "createdat", Value: bson.D{{Key: "$gt", Value: since}}}})
+	}
+	lastMinute, err := count("email", email, now.Add(-limit.cooldown).UnixMilli())
+	var lastHour, fromIP int64
+	if err == nil {
+		lastHour, err = count("email", email, hourAgo)
+	}
+	if err == nil {
+		fromIP, err = count("ip", ip, hourAgo)
+	}
+	if err != nil {
+		return false, err
+	}
+	if lastMinute > 0 || lastHour >= limit.perHour || fromIP >= limit.perIPHour {
+		return false, nil
+	}
+
+	request := bson.D{{Key: "kind", Value: limit.kind}, {Key: "email", Value: email}, {Key: "ip", Value: ip}, {Key: "createdat", Value: now.UnixMilli()}}
+	if _, err := emailRequestCollection.InsertOne(ctx, request); err != nil {
+		return false, err
+	}
+	return true, nil
+}
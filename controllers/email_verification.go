@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+	"github.com/achintya-7/go-fiber-chat/mailer"
+	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/responses"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// how long the code in a verification email stays valid
+const emailVerifyTTL = 48 * time.Hour
+
+// verification emails can be asked for again after this long, and this many times a day
+const verifyResendCooldown = time.Minute
+const maxVerifyResendsPerDay = 5
+
+// actions an account cant take until its email is verified, from UNVERIFIED_RESTRICTIONS as
+// a comma separated list out of create_chat, create_group, create_channel and send_message
+var unverifiedRestrictions = restrictionsFromEnv()
+
+const (
+	actionCreateChat    = "create_chat"
+	actionCreateGroup   = "create_group"
+	actionCreateChannel = "create_channel"
+	actionSendMessage   = "send_message"
+)
+
+func restrictionsFromEnv() map[string]bool {
+	value := configs.GetEnv("UNVERIFIED_RESTRICTIONS")
+	if value == "" {
+		value = actionCreateGroup
+	}
+
+	restrictions := map[string]bool{}
+	for _, action := range strings.Split(value, ",") {
+		if action = strings.TrimSpace(action); action != "" && action != "none" {
+			restrictions[action] = true
+		}
+	}
+	return restrictions
+}
+
+// sendVerificationEmail mails the user a code proving they own their email
+func sendVerificationEmail(ctx context.Context, user *models.User) error {
+	token, err := issueToken(ctx, user.Id, models.TokenEmailVerify, user.Email, emailVerifyTTL)
+	if err != nil {
+		return err
+	}
+	return mailer.Default.Send(user.Email, "Verify your email", "Use this code to verify your email address: "+token)
+}
+
+// blockedUnverified tells if the user still has to verify their email before taking the action
+func blockedUnverified(ctx context.Context, userId primitive.ObjectID, action string) (bool, error) {
+	if !unverifiedRestrictions[action] {
+		return false, nil
+	}
+
+	filter := bson.D{{Key: "id", Value: userId}, {Key: "emailverified", Value: true}}
+	count, err := userCollection.CountDocuments(ctx, filter)
+	return count == 0, err
+}
+
+func VerifyEmail(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	var req models.VerifyTokenReq
+	defer cancel()
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": validationErr.Error()}})
+	}
+
+	token, err := consumeToken(ctx, req.Token, models.TokenEmailVerify)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": errInvalidToken.Error()}})
+	}
+
+	// a code only verifies the email it was sent to, not one the user changed to since
+	filter := bson.D{{Key: "id", Value: token.UserId}, {Key: "email", Value: token.Email}}
+	result, err := userCollection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: bson.D{{Key: "emailverified", Value: true}}}})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if result.MatchedCount < 1 {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": errInvalidToken.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "Email verified"}})
+}
+
+// ResendVerification mails a new verification code, at most once a minute and a few times a day
+func ResendVerification(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	var user models.User
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(c.Params("userId"))
+
+	if err := userCollection.FindOne(ctx, bson.D{{Key: "id", Value: objId}}).Decode(&user); err != nil {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "User with specified ID not found!"}})
+	}
+	if user.EmailVerified {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Email is already verified"}})
+	}
+
+	now := time.Now()
+	recent := func(since time.Time) (int64, error) {
+		return tokenCollection.CountDocuments(ctx, bson.D{
+			{Key: "userid", Value: objId},
+			{Key: "purpose", Value: models.TokenEmailVerify},
+			{Key: "createdat", Value: bson.D{{Key: "$gt", Value: since.UnixMilli()}}},
+		})
+	}
+	lastMinute, err := recent(now.Add(-verifyResendCooldown))
+	var lastDay int64
+	if err == nil {
+		lastDay, err = recent(now.Add(-24 * time.Hour))
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if lastMinute > 0 || lastDay >= maxVerifyResendsPerDay {
+		return c.Status(http.StatusTooManyRequests).JSON(responses.UserResponse{Status: http.StatusTooManyRequests, Message: "error", Data: &fiber.Map{"data": "Too many verification emails, try again later"}})
+	}
+
+	if err := sendVerificationEmail(ctx, &user); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "Verification email sent"}})
+}
@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/responses"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultGroupsLimit = 20
+const maxGroupsLimit = 50
+
+func DiscoverGroups(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	search := strings.TrimSpace(c.Query("q"))
+
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit < 1 {
+		limit = defaultGroupsLimit
+	}
+	if limit > maxGroupsLimit {
+		limit = maxGroupsLimit
+	}
+	offset, err := strconv.Atoi(c.Query("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	match := bson.D{{Key: "chattype", Value: models.ChatTypeGroup}, {Key: "visibility", Value: models.GroupPublic}}
+	if search != "" {
+		match = append(match, bson.E{Key: "$text", Value: bson.D{{Key: "$search", Value: search}}})
+	}
+
+	// searches rank by relevance unless member count is asked for, browsing always ranks by size
+	sort := bson.D{{Key: "membercount", Value: -1}, {Key: "chatid", Value: -1}}
+	if search != "" && c.Query("sort") != "members" {
+		sort = bson.D{{Key: "score", Value: -1}, {Key: "membercount", Value: -1}}
+	}
+
+	addFields := bson.D{{Key: "membercount", Value: bson.D{{Key: "$size", Value: "$users"}}}}
+	if search != "" {
+		addFields = append(addFields, bson.E{Key: "score", Value: bson.D{{Key: "$meta", Value: "textScore"}}})
+	}
+
+	cursor, err := chatCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$addFields", Value: addFields}},
+		{{Key: "$sort", Value: sort}},
+		{{Key: "$skip", Value: offset}},
+		{{Key: "$limit", Value: limit}},
+		{
+			{
+				Key: "$project",
+				Value: bson.D{
+					{Key: "chatid", Value: 1},
+					{Key: "chatname", Value: 1},
+					{Key: "description", Value: 1},
+					{Key: "membercount", Value: 1},
+					{Key: "lastactivityat", Value: 1},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	groups := []models.DiscoverGroupRes{}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{
+		Status:  http.StatusOK,
+		Message: fmt.Sprintf("%d Groups were found", len(groups)),
+		Data:    &fiber.Map{"data": groups},
+	})
+}
+
+func JoinGroup(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	chatId, _ := primitive.ObjectIDFromHex(c.Params("chatId"))
+
+	// only the signed in user can join, nobody can be joined to a group by someone else
+	userId := authFrom(c).UserId
+
+	filter := bson.D{{Key: "chatid", Value: chatId}, {Key: "chattype", Value: models.ChatTypeGroup}, {Key: "visibility", Value: models.GroupPublic}}
+
+	var group models.CreateGroupChatRes
+	if err := chatCollection.FindOne(ctx, filter).Decode(&group); err != nil {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{
+			Status:  http.StatusNotFound,
+			Message: "Public group not found",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	if len(dedupeUserIds([]primitive.ObjectID{userId}, group.Users...)) == 0 {
+		return c.Status(http.StatusOK).JSON(responses.UserResponse{
+			Status:  http.StatusOK,
+			Message: "Already a member",
+			Data:    &fiber.Map{"data": group},
+		})
+	}
+
+	invalid, err := findInvalidUsers(ctx, []primitive.ObjectID{userId})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+	if len(invalid) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Some users are invalid",
+			Data:    &fiber.Map{"data": invalid},
+		})
+	}
+
+	filter = append(filter, hasRoomFor(1))
+	update := bson.D{{Key: "$addToSet", Value: bson.D{{Key: "users", Value: userId}}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := chatCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&group); err != nil {
+		return c.Status(http.StatusConflict).JSON(responses.UserResponse{
+			Status:  http.StatusConflict,
+			Message: fmt.Sprintf("A group can have at most %d users", maxGroupSize),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{
+		Status:  http.StatusOK,
+		Message: "Joined Group",
+		Data:    &fiber.Map{"data": group},
+	})
+}
+
+func UpdateGroup(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	chatId, _ := primitive.ObjectIDFromHex(c.Params("chatId"))
+
+	var req models.UpdateGroupReq
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Unable to parse JSON",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+	// the owner check is against whoever is signed in, whatever the body says
+	req.UserId = authFrom(c).UserId
+
+	set := bson.D{}
+	if req.Description != nil {
+		set = append(set, bson.E{Key: "description", Value: *req.Description})
+	}
+	if req.Visibility != nil {
+		if *req.Visibility != models.GroupPrivate && *req.Visibility != models.GroupPublic {
+			return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+				Status:  http.StatusBadRequest,
+				Message: "Visibility must be private or public",
+				Data:    &fiber.Map{"data": &fiber.Map{}},
+			})
+		}
+		set = append(set, bson.E{Key: "visibility", Value: *req.Visibility})
+	}
+	if len(set) == 0 {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Nothing to update",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	// only the group's owner can change how it is listed
+	filter := bson.D{{Key: "chatid", Value: chatId}, {Key: "chattype", Value: models.ChatTypeGroup}, {Key: "userid", Value: req.UserId}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var group models.CreateGroupChatRes
+	if err := chatCollection.FindOneAndUpdate(ctx, filter, bson.D{{Key: "$set", Value: set}}, opts).Decode(&group); err != nil {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{
+			Status:  http.StatusNotFound,
+			Message: "Group not found or not owned by user",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{
+		Status:  http.StatusOK,
+		Message: "Group Updated",
+		Data:    &fiber.Map{"data": group},
+	})
+}
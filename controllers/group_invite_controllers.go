@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/responses"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var groupInviteCollection *mongo.Collection = configs.GetCollection(configs.DB, "group_invites")
+
+func upsertGroupInvite(ctx context.Context, invite models.GroupInvite) error {
+	filter := bson.D{{Key: "chatid", Value: invite.ChatId}, {Key: "toid", Value: invite.ToId}}
+	update := bson.D{{Key: "$setOnInsert", Value: invite}}
+	_, err := groupInviteCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// a concurrent request sent the same invite
+		return nil
+	}
+	return err
+}
+
+func GetGroupInvites(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, _ := primitive.ObjectIDFromHex(c.Params("userId"))
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdat", Value: -1}})
+	cursor, err := groupInviteCollection.Find(ctx, bson.D{{Key: "toid", Value: userId}}, opts)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	invites := []models.GroupInvite{}
+	if err := cursor.All(ctx, &invites); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": invites}})
+}
+
+// AcceptGroupInvite adds the invited user to the group, as long as it still exists and has room
+func AcceptGroupInvite(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, _ := primitive.ObjectIDFromHex(c.Params("userId"))
+	inviteId, _ := primitive.ObjectIDFromHex(c.Params("inviteId"))
+
+	var invite models.GroupInvite
+	inviteFilter := bson.D{{Key: "inviteid", Value: inviteId}, {Key: "toid", Value: userId}}
+	if err := groupInviteCollection.FindOne(ctx, inviteFilter).Decode(&invite); err != nil {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "Invite not found"}})
+	}
+
+	filter := bson.D{{Key: "chatid", Value: invite.ChatId}, {Key: "chattype", Value: models.ChatTypeGroup}}
+	var group models.CreateGroupChatRes
+	if err := chatCollection.FindOne(ctx, filter).Decode(&group); err != nil {
+		// the group is gone, so the invite is no use anymore
+		groupInviteCollection.DeleteOne(ctx, inviteFilter)
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "Unable to find Group Chat"}})
+	}
+
+	if len(dedupeUserIds([]primitive.ObjectID{userId}, group.Users...)) > 0 {
+		filter = append(filter, hasRoomFor(1))
+		update := bson.D{{Key: "$addToSet", Value: bson.D{{Key: "users", Value: userId}}}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		if err := chatCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&group); err != nil {
+			return c.Status(http.StatusConflict).JSON(responses.UserResponse{Status: http.StatusConflict, Message: "error", Data: &fiber.Map{"data": fmt.Sprintf("A group can have at most %d users", maxGroupSize)}})
+		}
+	}
+
+	if _, err := groupInviteCollection.DeleteOne(ctx, inviteFilter); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": group}})
+}
+
+func DeclineGroupInvite(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId, _ := primitive.ObjectIDFromHex(c.Params("userId"))
+	inviteId, _ := primitive.ObjectIDFromHex(c.Params("inviteId"))
+
+	result, err := groupInviteCollection.DeleteOne(ctx, bson.D{{Key: "inviteid", Value: inviteId}, {Key: "toid", Value: userId}})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if result.DeletedCount < 1 {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "Invite not found"}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "Invite declined"}})
+}
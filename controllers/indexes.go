@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes creates the indexes the handlers rely on. Creating an existing index is a no-op.
+func EnsureIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	indexes := map[*mongo.Collection][]mongo.IndexModel{
+		chatCollection: {
+			{Keys: bson.D{{Key: "chatid", Value: 1}}, Options: options.Index().SetUnique(true)},
+			// inbox listing, newest activity first. Also serves every lookup of a member's chats.
+			{Keys: bson.D{{Key: "users", Value: 1}, {Key: "lastactivityat", Value: -1}, {Key: "chatid", Value: -1}}},
+			// group discovery
+			{Keys: bson.D{{Key: "chatname", Value: "text"}, {Key: "description", Value: "text"}}},
+			{Keys: bson.D{{Key: "chattype", Value: 1}, {Key: "visibility", Value: 1}}},
+			// one direct chat per pair of users, group chats have no pair key
+			{
+				Keys: bson.D{{Key: "pairkey", Value: 1}},
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(
+					bson.D{{Key: "pairkey", Value: bson.D{{Key: "$type", Value: "string"}}}},
+				),
+			},
+		},
+		userCollection: {
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			// one account per email, emails are stored normalized
+			{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+			// prefix search in SearchUsers
+			{Keys: bson.D{{Key: "searchname", Value: 1}, {Key: "id", Value: 1}}},
+			{Keys: bson.D{{Key: "searchemail", Value: 1}, {Key: "id", Value: 1}}},
+			// handles are unique regardless of case, users without one are left out
+			{
+				Keys: bson.D{{Key: "handlelower", Value: 1}},
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(
+					bson.D{{Key: "handlelower", Value: bson.D{{Key: "$gt", Value: ""}}}},
+				),
+			},
+			// a user's bots
+			{Keys: bson.D{{Key: "ownerid", Value: 1}}},
+		},
+		messageCollection: {
+			// a chat's messages in order
+			{Keys: bson.D{{Key: "roomid", Value: 1}, {Key: "timestamp", Value: 1}}},
+			// a user's messages, for account deletion and data export
+			{Keys: bson.D{{Key: "userid", Value: 1}}},
+			// the newest messages of every chat, for catching up chat activity
+			{Keys: bson.D{{Key: "timestamp", Value: 1}}},
+		},
+		sessionCollection: {
+			{Keys: bson.D{{Key: "tokenhash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "userid", Value: 1}}},
+		},
+		apiKeyCollection: {
+			{Keys: bson.D{{Key: "keyhash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "ownerid", Value: 1}, {Key: "createdat", Value: -1}}},
+			{Keys: bson.D{{Key: "userid", Value: 1}}},
+		},
+		identityCollection: {
+			{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "userid", Value: 1}}},
+		},
+		oidcStateCollection: {
+			{Keys: bson.D{{Key: "statehash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "expiresat", Value: 1}}},
+		},
+		signInCounterCollection: {
+			{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		auditCollection: {
+			{Keys: bson.D{{Key: "userid", Value: 1}, {Key: "createdat", Value: -1}}},
+			{Keys: bson.D{{Key: "ip", Value: 1}, {Key: "createdat", Value: -1}}},
+		},
+		emailRequestCollection: {
+			{Keys: bson.D{{Key: "kind", Value: 1}, {Key: "email", Value: 1}, {Key: "createdat", Value: 1}}},
+			{Keys: bson.D{{Key: "kind", Value: 1}, {Key: "ip", Value: 1}, {Key: "createdat", Value: 1}}},
+			{Keys: bson.D{{Key: "createdat", Value: 1}}},
+		},
+		passkeyCollection: {
+			{Keys: bson.D{{Key: "credentialid", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "userid", Value: 1}, {Key: "createdat", Value: 1}}},
+		},
+		tokenCollection: {
+			{Keys: bson.D{{Key: "tokenhash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "userid", Value: 1}, {Key: "purpose", Value: 1}}},
+		},
+		membershipCollection: {
+			{Keys: bson.D{{Key: "chatid", Value: 1}, {Key: "userid", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "userid", Value: 1}, {Key: "pinned", Value: 1}}},
+			{Keys: bson.D{{Key: "userid", Value: 1}, {Key: "archived", Value: 1}}},
+			{Keys: bson.D{{Key: "userid", Value: 1}, {Key: "subscribed", Value: 1}}},
+		},
+		blockCollection: {
+			{Keys: bson.D{{Key: "blockerid", Value: 1}, {Key: "blockedid", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "blockedid", Value: 1}, {Key: "blockerid", Value: 1}}},
+		},
+		contactCollection: {
+			// one request or contact per pair of users
+			{Keys: bson.D{{Key: "pairkey", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "toid", Value: 1}, {Key: "status", Value: 1}}},
+			{Keys: bson.D{{Key: "fromid", Value: 1}, {Key: "status", Value: 1}}},
+		},
+		groupInviteCollection: {
+			// one invite per user and group
+			{Keys: bson.D{{Key: "chatid", Value: 1}, {Key: "toid", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "toid", Value: 1}, {Key: "createdat", Value: -1}}},
+			{Keys: bson.D{{Key: "fromid", Value: 1}}},
+		},
+		jobCollection: {
+			{Keys: bson.D{{Key: "jobid", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "lockeduntil", Value: 1}, {Key: "createdat", Value: 1}}},
+			// a user's recent jobs, for limiting exports
+			{Keys: bson.D{{Key: "userid", Value: 1}, {Key: "type", Value: 1}, {Key: "createdat", Value: 1}}},
+		},
+		reactionCollection: {
+			// a user reacts with each emoji once per message
+			{Keys: bson.D{{Key: "messageid", Value: 1}, {Key: "userid", Value: 1}, {Key: "emoji", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+	}
+
+	for collection, collectionIndexes := range indexes {
+		if _, err := collection.Indexes().CreateMany(ctx, collectionIndexes); err != nil {
+			log.Fatalf("Unable to create indexes on %s: %v", collection.Name(), err)
+		}
+	}
+}
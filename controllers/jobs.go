@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/responses"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var jobCollection *mongo.Collection = configs.GetCollection(configs.DB, "jobs")
+
+// a claimed job is given this long before another worker may pick it up again
+const jobLease = 5 * time.Minute
+
+// failed jobs are retried until they were attempted this many times
+const maxJobAttempts = 5
+
+// jobStep is one idempotent piece of a job, safe to run again if the job was interrupted
+type jobStep func(ctx context.Context, job *models.Job) error
+
+// jobSteps lists the steps of every job type in the order they run
+var jobSteps = map[models.JobType][]jobStep{
+	models.JobDeleteUser: deleteUserSteps,
+	models.JobExportUser: exportUserSteps,
+}
+
+// enqueueJob stores a job for the worker, or returns the unfinished job of the same type
+// for the same user
+func enqueueJob(ctx context.Context, jobType models.JobType, userId primitive.ObjectID) (*models.Job, error) {
+	now := time.Now().UnixMilli()
+	filter := bson.D{
+		{Key: "type", Value: jobType},
+		{Key: "userid", Value: userId},
+		{Key: "status", Value: bson.D{{Key: "$in", Value: bson.A{models.JobPending, models.JobRunning}}}},
+	}
+	update := bson.D{{Key: "$setOnInsert", Value: models.Job{
+		JobId:     primitive.NewObjectID(),
+		Type:      jobType,
+		UserId:    userId,
+		Status:    models.JobPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var job models.Job
+	if err := jobCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// StartJobWorker runs queued jobs in the background, including jobs left unfinished by a
+// previous run of the server
+func StartJobWorker() {
+	startExportSweeper()
+	go func() {
+		for {
+			ran, err := runNextJob()
+			if err != nil {
+				log.Print(err)
+			}
+			if !ran {
+				time.Sleep(5 * time.Second)
+			}
+		}
+	}()
+}
+
+// runNextJob claims one due job and runs its remaining steps, reporting if there was one
+func runNextJob() (bool, error) {
+	claimCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.D{
+		{Key: "status", Value: bson.D{{Key: "$in", Value: bson.A{models.JobPending, models.JobRunning}}}},
+		{Key: "lockeduntil", Value: bson.D{{Key: "$lt", Value: now.UnixMilli()}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "status", Value: models.JobRunning},
+		{Key: "lockeduntil", Value: now.Add(jobLease).UnixMilli()},
+		{Key: "updatedat", Value: now.UnixMilli()},
+	}}}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "createdat", Value: 1}}).SetReturnDocument(options.After)
+
+	var job models.Job
+	if err := jobCollection.FindOneAndUpdate(claimCtx, filter, update, opts).Decode(&job); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return false, nil
+		}
+		return false, err
+	}
+
+	ctx, cancelRun := context.WithTimeout(context.Background(), jobLease)
+	defer cancelRun()
+
+	steps := jobSteps[job.Type]
+	for job.Step < len(steps) {
+		if err := steps[job.Step](ctx, &job); err != nil {
+			return true, failJob(&job, err)
+		}
+		job.Step++
+		if err := saveJob(ctx, &job, bson.D{{Key: "step", Value: job.Step}}); err != nil {
+			return true, err
+		}
+	}
+
+	return true, saveJob(ctx, &job, bson.D{{Key: "status", Value: models.JobDone}, {Key: "lockeduntil", Value: int64(0)}})
+}
+
+// failJob gives the job back to the queue to retry from its current step, until it runs out of attempts
+func failJob(job *models.Job, cause error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	job.Attempts++
+	status := models.JobPending
+	if job.Attempts >= maxJobAttempts {
+		status = models.JobFailed
+	}
+
+	// back off before the next attempt
+	retryAt := time.Now().Add(time.Duration(job.Attempts) * time.Minute).UnixMilli()
+	err := saveJob(ctx, job, bson.D{
+		{Key: "status", Value: status},
+		{Key: "attempts", Value: job.Attempts},
+		{Key: "error", Value: cause.Error()},
+		{Key: "lockeduntil", Value: retryAt},
+	})
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("job %s failed at step %d: %w", job.JobId.Hex(), job.Step, cause)
+}
+
+func saveJob(ctx context.Context, job *models.Job, set bson.D) error {
+	set = append(set, bson.E{Key: "updatedat", Value: time.Now().UnixMilli()})
+	_, err := jobCollection.UpdateOne(ctx, bson.D{{Key: "jobid", Value: job.JobId}}, bson.D{{Key: "$set", Value: set}})
+	return err
+}
+
+// GetJob shows a job to the user it is for, signed in with a session
+func GetJob(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	session, err := sessionFromRequest(ctx, c)
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(responses.UserResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": errInvalidToken.Error()}})
+	}
+
+	jobId, _ := primitive.ObjectIDFromHex(c.Params("jobId"))
+
+	// jobs of other users are not found, so their ids cant be probed
+	var job models.Job
+	if err := jobCollection.FindOne(ctx, bson.D{{Key: "jobid", Value: jobId}, {Key: "userid", Value: session.UserId}}).Decode(&job); err != nil {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "Job not found"}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": job}})
+}
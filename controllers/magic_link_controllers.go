@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+	"github.com/achintya-7/go-fiber-chat/mailer"
+	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/responses"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// signing in with an emailed link is off unless MAGIC_LINKS is set
+var magicLinksEnabled = configs.GetEnvBool("MAGIC_LINKS", false)
+
+// links only work in the browser that asked for them, unless MAGIC_LINK_BIND_DEVICE is false
+var magicLinkBindDevice = configs.GetEnvBool("MAGIC_LINK_BIND_DEVICE", true)
+
+// where the emailed link points, the token is added as a query parameter. A frontend page can
+// take the token and call VerifyMagicLink.
+var magicLinkURL = magicLinkURLFromEnv()
+
+func magicLinkURLFromEnv() string {
+	if url := configs.GetEnv("MAGIC_LINK_URL"); url != "" {
+		return url
+	}
+	return "/auth/magic-link/verify"
+}
+
+const magicLinkTTL = 15 * time.Minute
+
+// links can be asked for again after a minute, and a few times an hour, per email
+var magicLinkLimit = emailLimit{kind: "magic_link", cooldown: time.Minute, perHour: 5, perIPHour: 20}
+
+const magicLinkDeviceCookie = "magic_link_device"
+
+// RequestMagicLink mails a sign in link to the account with this email. It answers the same
+// whether or not the account exists, and limits how often an email can be sent links, also
+// whether or not it exists.
+func RequestMagicLink(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	var req models.MagicLinkReq
+	defer cancel()
+
+	if !magicLinksEnabled {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "Sign in links are not enabled"}})
+	}
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": validationErr.Error()}})
+	}
+
+	email := models.NormalizeEmail(req.Email)
+	now := time.Now()
+
+	allowed, err := magicLinkLimit.allow(ctx, email, c.IP())
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if !allowed {
+		return c.Status(http.StatusTooManyRequests).JSON(responses.UserResponse{Status: http.StatusTooManyRequests, Message: "error", Data: &fiber.Map{"data": "Too many sign in links, try again later"}})
+	}
+
+	// every request gets a device secret, so the answer doesnt tell if a link was sent
+	device, err := models.NewToken()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if magicLinkBindDevice {
+		c.Cookie(&fiber.Cookie{
+			Name:     magicLinkDeviceCookie,
+			Value:    device,
+			Path:     "/auth/magic-link",
+			Expires:  now.Add(magicLinkTTL),
+			HTTPOnly: true,
+			Secure:   c.Protocol() == "https",
+			SameSite: fiber.CookieSameSiteLaxMode,
+		})
+	}
+
+	var user models.User
+	filter := bson.D{{Key: "email", Value: email}, {Key: "deleting", Value: bson.D{{Key: "$ne", Value: true}}}, {Key: "isbot", Value: bson.D{{Key: "$ne", Value: true}}}}
+	if err := userCollection.FindOne(ctx, filter).Decode(&user); err == nil {
+		if err := sendMagicLink(ctx, &user, device); err != nil {
+			log.Print(err)
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "If an account uses this email, a sign in link was sent to it"}})
+}
+
+func sendMagicLink(ctx context.Context, user *models.User, device string) error {
+	token, err := issueToken(ctx, user.Id, models.TokenMagicLink, user.Email, magicLinkTTL)
+	if err != nil {
+		return err
+	}
+	if magicLinkBindDevice {
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "devicehash", Value: models.HashToken(device)}}}}
+		if _, err := tokenCollection.UpdateOne(ctx, bson.D{{Key: "tokenhash", Value: models.HashToken(token)}}, update); err != nil {
+			return err
+		}
+	}
+
+	link := magicLinkURL + "?token=" + token
+	body := "Open this link to sign in: " + link + "\nIt works once, for 15 minutes"
+	if magicLinkBindDevice {
+		body += ", in the browser you asked for it from"
+	}
+	body += ". If you did not ask for it, you can ignore this email."
+
+	// sent in the background so a slow mail server doesnt make known emails answer slower
+	go func() {
+		if err := mailer.Default.Send(user.Email, "Your sign in link", body); err != nil {
+			log.Print(err)
+		}
+	}()
+	return nil
+}
+
+// VerifyMagicLink signs in with the token from a sign in link
+func VerifyMagicLink(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	var record models.OneTimeToken
+	var user models.User
+	defer cancel()
+
+	if !magicLinksEnabled {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "Sign in links are not enabled"}})
+	}
+
+	token := c.Query("token")
+
+	// the device is checked before the token is used up, so a mail scanner opening the link
+	// doesnt spend it
+	filter := bson.D{
+		{Key: "tokenhash", Value: models.HashToken(token)},
+		{Key: "purpose", Value: models.TokenMagicLink},
+		{Key: "used", Value: false},
+		{Key: "expiresat", Value: bson.D{{Key: "$gt", Value: time.Now().UnixMilli()}}},
+	}
+	if err := tokenCollection.FindOne(ctx, filter).Decode(&record); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": errInvalidToken.Error()}})
+	}
+	if record.DeviceHash != "" && models.HashToken(c.Cookies(magicLinkDeviceCookie)) != record.DeviceHash {
+		return c.Status(http.StatusForbidden).JSON(responses.UserResponse{Status: http.StatusForbidden, Message: "error", Data: &fiber.Map{"data": "Open the link in the browser you asked for it from"}})
+	}
+
+	if _, err := consumeToken(ctx, token, models.TokenMagicLink); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": errInvalidToken.Error()}})
+	}
+	c.Cookie(&fiber.Cookie{Name: magicLinkDeviceCookie, Path: "/auth/magic-link", Expires: time.Unix(0, 0), HTTPOnly: true})
+
+	// a link only signs in with the email it was sent to, not one the user changed to since
+	filter = bson.D{{Key: "id", Value: record.UserId}, {Key: "email", Value: record.Email}, {Key: "deleting", Value: bson.D{{Key: "$ne", Value: true}}}}
+	if err := userCollection.FindOne(ctx, filter).Decode(&user); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": errInvalidToken.Error()}})
+	}
+
+	// opening the link proves the user owns the email
+	if !user.EmailVerified {
+		if _, err := userCollection.UpdateOne(ctx, bson.D{{Key: "id", Value: user.Id}}, bson.D{{Key: "$set", Value: bson.D{{Key: "emailverified", Value: true}}}}); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+		}
+		user.EmailVerified = true
+	}
+
+	return continueSignIn(ctx, c, user)
+}
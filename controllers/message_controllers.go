@@ -0,0 +1,290 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/responses"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var reactionCollection *mongo.Collection = configs.GetCollection(configs.DB, "reactions")
+
+func SendMessage(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.SendMessageReq
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Unable to parse JSON",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	if strings.TrimSpace(req.Content) == "" {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Message content is empty",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+	if req.ContentType == "" {
+		req.ContentType = "text"
+	}
+
+	// the author is whoever the request is authenticated as, not what the body claims
+	auth := authFrom(c)
+	req.UserId = auth.UserId
+	if !auth.allowsChat(req.RoomId) {
+		return c.Status(http.StatusForbidden).JSON(responses.UserResponse{
+			Status:  http.StatusForbidden,
+			Message: "API key cant be used in this chat",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	blocked, err := blockedUnverified(ctx, req.UserId, actionSendMessage)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+	if blocked {
+		return c.Status(http.StatusForbidden).JSON(responses.UserResponse{
+			Status:  http.StatusForbidden,
+			Message: "Verify your email to send messages",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	chat, err := findReadableChat(ctx, req.RoomId, req.UserId)
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{
+			Status:  http.StatusNotFound,
+			Message: "Chat not found",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+	if !canPost(chat, req.UserId) {
+		return c.Status(http.StatusForbidden).JSON(responses.UserResponse{
+			Status:  http.StatusForbidden,
+			Message: "Only admins can post in a channel",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	if chat.ChatType == models.ChatTypeDirect {
+		deleted, err := chatCollection.CountDocuments(ctx, bson.D{{Key: "chatid", Value: chat.ChatId}, {Key: "deletedusers.0", Value: bson.D{{Key: "$exists", Value: true}}}})
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+				Status:  http.StatusInternalServerError,
+				Message: err.Error(),
+				Data:    &fiber.Map{"data": &fiber.Map{}},
+			})
+		}
+		if deleted > 0 {
+			return c.Status(http.StatusForbidden).JSON(responses.UserResponse{
+				Status:  http.StatusForbidden,
+				Message: "This user has deleted their account",
+				Data:    &fiber.Map{"data": &fiber.Map{}},
+			})
+		}
+
+		for _, memberId := range chat.Users {
+			if memberId == req.UserId {
+				continue
+			}
+			blocked, err := isBlockedEither(ctx, req.UserId, memberId)
+			if err != nil {
+				return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+					Status:  http.StatusInternalServerError,
+					Message: err.Error(),
+					Data:    &fiber.Map{"data": &fiber.Map{}},
+				})
+			}
+			if blocked {
+				return c.Status(http.StatusForbidden).JSON(responses.UserResponse{
+					Status:  http.StatusForbidden,
+					Message: "Cant send messages to this user",
+					Data:    &fiber.Map{"data": &fiber.Map{}},
+				})
+			}
+		}
+	}
+
+	message := models.Message{
+		UserId:      req.UserId,
+		RoomId:      req.RoomId,
+		Content:     req.Content,
+		ContentType: req.ContentType,
+		MessageId:   primitive.NewObjectID().Hex(),
+		Timestamp:   time.Now().UnixMilli(),
+	}
+
+	if _, err := messageCollection.InsertOne(ctx, message); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	if err := touchPresence(ctx, req.UserId); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	if err := touchChat(ctx, message); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	return c.Status(http.StatusCreated).JSON(responses.UserResponse{
+		Status:  http.StatusCreated,
+		Message: "Message Sent",
+		Data:    &fiber.Map{"data": message},
+	})
+}
+
+// touchChat records a message as the latest activity of its chat. Older messages arriving
+// late never replace a newer latest message.
+func touchChat(ctx context.Context, message models.Message) error {
+	filter := bson.D{
+		{Key: "chatid", Value: message.RoomId},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "lastactivityat", Value: bson.D{{Key: "$lte", Value: message.Timestamp}}}},
+			bson.D{{Key: "lastactivityat", Value: bson.D{{Key: "$exists", Value: false}}}},
+		}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "latestmessage", Value: message.Content},
+		{Key: "latestmessageid", Value: message.MessageId},
+		{Key: "lastactivityat", Value: message.Timestamp},
+	}}}
+
+	_, err := chatCollection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func AddReaction(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.ReactionReq
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Unable to parse JSON",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	if strings.TrimSpace(req.Emoji) == "" {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Reaction is empty",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	var message models.Message
+	if err := messageCollection.FindOne(ctx, bson.D{{Key: "messageid", Value: req.MessageId}}).Decode(&message); err != nil {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{
+			Status:  http.StatusNotFound,
+			Message: "Message not found",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	// channel subscribers cant post but they can react
+	auth := authFrom(c)
+	req.UserId = auth.UserId
+	if _, err := findReadableChat(ctx, message.RoomId, req.UserId); err != nil || !auth.allowsChat(message.RoomId) {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{
+			Status:  http.StatusNotFound,
+			Message: "Chat not found",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	reaction := models.Reaction{
+		MessageId: message.MessageId,
+		RoomId:    message.RoomId,
+		UserId:    req.UserId,
+		Emoji:     req.Emoji,
+		Timestamp: time.Now().UnixMilli(),
+	}
+
+	if _, err := reactionCollection.InsertOne(ctx, reaction); err != nil && !mongo.IsDuplicateKeyError(err) {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{
+		Status:  http.StatusOK,
+		Message: "Reaction Added",
+		Data:    &fiber.Map{"data": reaction},
+	})
+}
+
+func RemoveReaction(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.ReactionReq
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{
+			Status:  http.StatusBadRequest,
+			Message: "Unable to parse JSON",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	auth := authFrom(c)
+	req.UserId = auth.UserId
+	filter := bson.D{{Key: "messageid", Value: req.MessageId}, {Key: "userid", Value: req.UserId}, {Key: "emoji", Value: req.Emoji}}
+	if auth.Key != nil && len(auth.Key.ChatIds) > 0 {
+		filter = append(filter, bson.E{Key: "roomid", Value: bson.D{{Key: "$in", Value: auth.Key.ChatIds}}})
+	}
+	result, err := reactionCollection.DeleteOne(ctx, filter)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+	if result.DeletedCount < 1 {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{
+			Status:  http.StatusNotFound,
+			Message: "Reaction not found",
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{
+		Status:  http.StatusOK,
+		Message: "Reaction Removed",
+		Data:    &fiber.Map{"data": req},
+	})
+}
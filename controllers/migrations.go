@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RunMigrations brings documents written by older versions up to the current shape.
+// Every step is safe to run on each start.
+func RunMigrations() {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	// the isgroup flag became a chat type
+	for _, isGroup := range []bool{true, false} {
+		chatType := models.ChatTypeDirect
+		if isGroup {
+			chatType = models.ChatTypeGroup
+		}
+		update := bson.D{
+			{Key: "$set", Value: bson.D{{Key: "chattype", Value: chatType}}},
+			{Key: "$unset", Value: bson.D{{Key: "isgroup", Value: ""}}},
+		}
+		if _, err := chatCollection.UpdateMany(ctx, bson.D{{Key: "chattype", Value: bson.D{{Key: "$exists", Value: false}}}, {Key: "isgroup", Value: isGroup}}, update); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// chats created before activity tracking start from their creation time
+	backfill := bson.A{
+		bson.D{{Key: "$set", Value: bson.D{{Key: "lastactivityat", Value: bson.D{{Key: "$toLong", Value: bson.D{{Key: "$toDate", Value: "$chatid"}}}}}}}},
+	}
+	result, err := chatCollection.UpdateMany(ctx, bson.D{{Key: "lastactivityat", Value: bson.D{{Key: "$exists", Value: false}}}}, backfill)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("Backfilled activity time of %d chats", result.ModifiedCount)
+	}
+
+	// users created before search keys were stored
+	searchKeys := bson.A{
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "searchname", Value: bson.D{{Key: "$toLower", Value: bson.D{{Key: "$trim", Value: bson.D{{Key: "input", Value: "$name"}}}}}}},
+			{Key: "searchemail", Value: bson.D{{Key: "$toLower", Value: bson.D{{Key: "$trim", Value: bson.D{{Key: "input", Value: "$email"}}}}}}},
+		}}},
+	}
+	if _, err := userCollection.UpdateMany(ctx, bson.D{{Key: "searchname", Value: bson.D{{Key: "$exists", Value: false}}}}, searchKeys); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := normalizeEmails(ctx); err != nil {
+		log.Fatal(err)
+	}
+
+	// accounts from before email verification keep working as they did
+	if _, err := userCollection.UpdateMany(ctx, bson.D{{Key: "emailverified", Value: bson.D{{Key: "$exists", Value: false}}}}, bson.D{{Key: "$set", Value: bson.D{{Key: "emailverified", Value: true}}}}); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := mergeDuplicateDirectChats(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// normalizeEmails stores every email the way NormalizeEmail does. Accounts that turn out to
+// share an email cant be merged automatically, so they keep their emails as they are, are
+// logged and flagged with emailconflict for merging by hand, and the server starts anyway.
+func normalizeEmails(ctx context.Context) error {
+	normalized := bson.D{{Key: "$toLower", Value: bson.D{{Key: "$trim", Value: bson.D{{Key: "input", Value: "$email"}}}}}}
+
+	cursor, err := userCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: normalized}, {Key: "ids", Value: bson.D{{Key: "$push", Value: "$id"}}}}}},
+		{{Key: "$match", Value: bson.D{{Key: "ids.1", Value: bson.D{{Key: "$exists", Value: true}}}}}},
+	})
+	if err != nil {
+		return err
+	}
+	var duplicates []struct {
+		Email string               `bson:"_id"`
+		Ids   []primitive.ObjectID `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return err
+	}
+
+	conflicted := []primitive.ObjectID{}
+	for _, duplicate := range duplicates {
+		ids := make([]string, 0, len(duplicate.Ids))
+		for _, id := range duplicate.Ids {
+			ids = append(ids, id.Hex())
+		}
+		log.Printf("Accounts %s share the email %s, give each its own email or merge them", strings.Join(ids, ", "), duplicate.Email)
+		conflicted = append(conflicted, duplicate.Ids...)
+	}
+
+	flag := bson.D{{Key: "$set", Value: bson.D{{Key: "emailconflict", Value: true}}}}
+	if _, err := userCollection.UpdateMany(ctx, bson.D{{Key: "id", Value: bson.D{{Key: "$in", Value: conflicted}}}}, flag); err != nil {
+		return err
+	}
+	// accounts resolved since the last start lose the flag
+	unflag := bson.D{{Key: "$unset", Value: bson.D{{Key: "emailconflict", Value: ""}}}}
+	if _, err := userCollection.UpdateMany(ctx, bson.D{{Key: "emailconflict", Value: true}, {Key: "id", Value: bson.D{{Key: "$nin", Value: conflicted}}}}, unflag); err != nil {
+		return err
+	}
+
+	filter := bson.D{
+		{Key: "$expr", Value: bson.D{{Key: "$ne", Value: bson.A{"$email", normalized}}}},
+		{Key: "id", Value: bson.D{{Key: "$nin", Value: conflicted}}},
+	}
+	update := bson.A{bson.D{{Key: "$set", Value: bson.D{{Key: "email", Value: normalized}, {Key: "searchemail", Value: normalized}}}}}
+	result, err := userCollection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("Normalized the email of %d users", result.ModifiedCount)
+	}
+	return nil
+}
+
+// mergeDuplicateDirectChats gives every direct chat its pair key. When the same users ended up
+// with several direct chats, the keyed or else oldest one is kept and the others are folded into it.
+func mergeDuplicateDirectChats(ctx context.Context) error {
+	unkeyed := bson.D{{Key: "chattype", Value: models.ChatTypeDirect}, {Key: "pairkey", Value: bson.D{{Key: "$exists", Value: false}}}}
+	count, err := chatCollection.CountDocuments(ctx, unkeyed)
+	if err != nil || count == 0 {
+		return err
+	}
+
+	cursor, err := chatCollection.Find(ctx, bson.D{{Key: "chattype", Value: models.ChatTypeDirect}}, options.Find().SetSort(bson.D{{Key: "chatid", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	var chats []models.CreateChatRes
+	if err := cursor.All(ctx, &chats); err != nil {
+		return err
+	}
+
+	groups := make(map[string][]models.CreateChatRes)
+	var keys []string
+	for _, chat := range chats {
+		if len(chat.Users) == 0 || len(chat.Users) > 2 {
+			log.Printf("Skipping direct chat %s with %d users", chat.ChatId.Hex(), len(chat.Users))
+			continue
+		}
+		key := models.DirectPairKey(chat.Users...)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], chat)
+	}
+
+	merged := 0
+	for _, key := range keys {
+		group := groups[key]
+
+		keep := 0
+		for i, chat := range group {
+			if chat.PairKey != "" {
+				keep = i
+				break
+			}
+		}
+		canonical := group[keep]
+
+		for i, duplicate := range group {
+			if i == keep {
+				continue
+			}
+			if err := foldDirectChat(ctx, duplicate.ChatId, canonical.ChatId); err != nil {
+				return err
+			}
+			if duplicate.LastActivityAt > canonical.LastActivityAt {
+				canonical.LatestMessage = duplicate.LatestMessage
+				canonical.LatestMessageId = duplicate.LatestMessageId
+				canonical.LastActivityAt = duplicate.LastActivityAt
+			}
+			merged++
+		}
+
+		update := bson.D{{Key: "$set", Value: bson.D{
+			{Key: "pairkey", Value: key},
+			{Key: "users", Value: dedupeUserIds(canonical.Users)},
+			{Key: "latestmessage", Value: canonical.LatestMessage},
+			{Key: "latestmessageid", Value: canonical.LatestMessageId},
+			{Key: "lastactivityat", Value: canonical.LastActivityAt},
+		}}}
+		if _, err := chatCollection.UpdateOne(ctx, bson.D{{Key: "chatid", Value: canonical.ChatId}}, update); err != nil {
+			return err
+		}
+	}
+
+	if merged > 0 {
+		log.Printf("Merged %d duplicate direct chats", merged)
+	}
+	return nil
+}
+
+// foldDirectChat moves the messages and member settings of one chat into another, then deletes it
+func foldDirectChat(ctx context.Context, fromId primitive.ObjectID, toId primitive.ObjectID) error {
+	repoint := bson.D{{Key: "$set", Value: bson.D{{Key: "roomid", Value: toId}}}}
+	if _, err := messageCollection.UpdateMany(ctx, bson.D{{Key: "roomid", Value: fromId}}, repoint); err != nil {
+		return err
+	}
+
+	cursor, err := membershipCollection.Find(ctx, bson.D{{Key: "chatid", Value: fromId}})
+	if err != nil {
+		return err
+	}
+	var states []models.ChatMembership
+	if err := cursor.All(ctx, &states); err != nil {
+		return err
+	}
+	for _, state := range states {
+		// settings already saved on the kept chat win
+		exists, err := membershipCollection.CountDocuments(ctx, bson.D{{Key: "chatid", Value: toId}, {Key: "userid", Value: state.UserId}})
+		if err != nil {
+			return err
+		}
+		if exists == 0 {
+			_, err = membershipCollection.UpdateOne(ctx,
+				bson.D{{Key: "chatid", Value: fromId}, {Key: "userid", Value: state.UserId}},
+				bson.D{{Key: "$set", Value: bson.D{{Key: "chatid", Value: toId}}}})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := membershipCollection.DeleteMany(ctx, bson.D{{Key: "chatid", Value: fromId}}); err != nil {
+		return err
+	}
+
+	_, err = chatCollection.DeleteOne(ctx, bson.D{{Key: "chatid", Value: fromId}})
+	return err
+}
@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/oidc"
+	"github.com/achintya-7/go-fiber-chat/responses"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var identityCollection *mongo.Collection = configs.GetCollection(configs.DB, "identities")
+var oidcStateCollection *mongo.Collection = configs.GetCollection(configs.DB, "oidc_states")
+
+// the sign in providers by name, see oidc.FromEnv
+var oidcProviders = oidc.FromEnv(configs.GetEnv)
+
+// how long the user has to sign in at the provider and come back
+const oidcStateTTL = 10 * time.Minute
+
+// the state is also kept in this cookie, so a callback only works in the browser that started
+// the sign in and a link with someone else's state cant sign a victim into that account
+const oidcStateCookie = "oidc_state"
+
+// StartOIDCSignIn sends the user to the provider to sign in. The provider sends them back to
+// OIDCCallback.
+func StartOIDCSignIn(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	provider, ok := oidcProviders[c.Params("provider")]
+	if !ok {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "Unknown sign in provider"}})
+	}
+
+	state, err := models.NewToken()
+	var nonce, verifier string
+	if err == nil {
+		nonce, err = models.NewToken()
+	}
+	if err == nil {
+		verifier, err = oidc.NewVerifier()
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	redirect, err := provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		log.Print(err)
+		return c.Status(http.StatusBadGateway).JSON(responses.UserResponse{Status: http.StatusBadGateway, Message: "error", Data: &fiber.Map{"data": "Sign in provider is unavailable"}})
+	}
+
+	now := time.Now()
+	if _, err := oidcStateCollection.DeleteMany(ctx, bson.D{{Key: "expiresat", Value: bson.D{{Key: "$lt", Value: now.UnixMilli()}}}}); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	pending := models.OIDCState{
+		StateHash: models.HashToken(state),
+		Provider:  provider.Name,
+		Verifier:  verifier,
+		Nonce:     nonce,
+		CreatedAt: now.UnixMilli(),
+		ExpiresAt: now.Add(oidcStateTTL).UnixMilli(),
+	}
+	if _, err := oidcStateCollection.InsertOne(ctx, pending); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc",
+		Expires:  now.Add(oidcStateTTL),
+		HTTPOnly: true,
+		Secure:   c.Protocol() == "https",
+		// sent along when the provider redirects back, which is a top level navigation
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	return c.Redirect(redirect, http.StatusFound)
+}
+
+// OIDCCallback finishes signing in with a provider. The provider's account is linked to the
+// user with the same verified email, or a new user is made for it.
+func OIDCCallback(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	var pending models.OIDCState
+	defer cancel()
+
+	provider, ok := oidcProviders[c.Params("provider")]
+	if !ok {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "Unknown sign in provider"}})
+	}
+
+	if reason := c.Query("error"); reason != "" {
+		return c.Status(http.StatusUnauthorized).JSON(responses.UserResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Sign in was cancelled at the provider: " + reason}})
+	}
+
+	state := c.Query("state")
+	cookie := c.Cookies(oidcStateCookie)
+	c.Cookie(&fiber.Cookie{Name: oidcStateCookie, Path: "/auth/oidc", Expires: time.Unix(0, 0), HTTPOnly: true})
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookie)) != 1 {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Sign in was started in another browser, start again"}})
+	}
+
+	// a state is good for one try, so a replayed callback finds nothing
+	filter := bson.D{
+		{Key: "statehash", Value: models.HashToken(state)},
+		{Key: "provider", Value: provider.Name},
+		{Key: "expiresat", Value: bson.D{{Key: "$gt", Value: time.Now().UnixMilli()}}},
+	}
+	if err := oidcStateCollection.FindOneAndDelete(ctx, filter).Decode(&pending); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Sign in expired, start again"}})
+	}
+
+	claims, err := provider.Exchange(ctx, c.Query("code"), pending.Verifier, pending.Nonce)
+	if err != nil {
+		log.Print(err)
+		return c.Status(http.StatusUnauthorized).JSON(responses.UserResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Sign in with the provider failed"}})
+	}
+
+	user, err := userForIdentity(ctx, provider.Name, claims)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if userErr, ok := err.(*fiber.Error); ok {
+			status = userErr.Code
+		}
+		return c.Status(status).JSON(responses.UserResponse{Status: status, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return continueSignIn(ctx, c, *user)
+}
+
+// userForIdentity finds the user a provider's account signs in as, linking or creating one the
+// first time. Errors the user can act on are *fiber.Error with the status to answer with.
+func userForIdentity(ctx context.Context, provider string, claims *oidc.Claims) (*models.User, error) {
+	var identity models.Identity
+	var user models.User
+
+	err := identityCollection.FindOne(ctx, bson.D{{Key: "provider", Value: provider}, {Key: "subject", Value: claims.Subject}}).Decode(&identity)
+	if err == nil {
+		filter := bson.D{{Key: "id", Value: identity.UserId}, {Key: "deleting", Value: bson.D{{Key: "$ne", Value: true}}}}
+		if err := userCollection.FindOne(ctx, filter).Decode(&user); err != nil {
+			return nil, fiber.NewError(http.StatusUnauthorized, "The linked account no longer exists")
+		}
+		return &user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	// only an email the provider vouches for may take over or claim an account
+	email := models.NormalizeEmail(claims.Email)
+	if !claims.EmailVerified || !models.ValidEmail(email) {
+		return nil, fiber.NewError(http.StatusForbidden, "The provider did not share a verified email")
+	}
+
+	err = userCollection.FindOne(ctx, bson.D{{Key: "email", Value: email}}).Decode(&user)
+	switch {
+	case err == nil:
+		if user.Deleting || user.IsBot {
+			return nil, fiber.NewError(http.StatusForbidden, "This email cant be used to sign in")
+		}
+		// whoever registered the email without proving they own it shouldnt gain the
+		// provider's account, nor the provider's account theirs
+		if !user.EmailVerified {
+			return nil, fiber.NewError(http.StatusConflict, "Verify the email of the existing account before signing in with a provider")
+		}
+	case err == mongo.ErrNoDocuments:
+		name := strings.TrimSpace(claims.Name)
+		if name == "" {
+			name = email[:strings.Index(email, "@")]
+		}
+		user = models.User{
+			Id:            primitive.NewObjectID(),
+			Name:          name,
+			Email:         email,
+			EmailVerified: true,
+		}
+		user.SetSearchKeys()
+		if _, err := userCollection.InsertOne(ctx, user); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return nil, fiber.NewError(http.StatusConflict, "Email is already in use")
+			}
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	identity = models.Identity{
+		Provider:  provider,
+		Subject:   claims.Subject,
+		UserId:    user.Id,
+		Email:     email,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	if _, err := identityCollection.InsertOne(ctx, identity); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, fiber.NewError(http.StatusConflict, "Sign in is already in progress, try again")
+		}
+		return nil, err
+	}
+	return &user, nil
+}
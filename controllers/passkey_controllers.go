@@ -0,0 +1,286 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/responses"
+	"github.com/achintya-7/go-fiber-chat/webauthn"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var passkeyCollection *mongo.Collection = configs.GetCollection(configs.DB, "passkeys")
+
+// this server as passkeys see it, see webauthn.FromEnv
+var relyingParty = webauthn.FromEnv(configs.GetEnv)
+
+// how long the browser has to finish a passkey ceremony, a little over the timeout it is given
+const passkeyChallengeTTL = 6 * time.Minute
+
+// a user can have this many passkeys
+const maxPasskeys = 20
+
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+func passkeysDisabled(c *fiber.Ctx) error {
+	return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "Passkeys are not enabled"}})
+}
+
+// BeginPasskeyRegistration returns the options to make a new passkey with in the browser
+func BeginPasskeyRegistration(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	var user models.User
+	defer cancel()
+
+	if !relyingParty.Enabled() {
+		return passkeysDisabled(c)
+	}
+
+	userId := authFrom(c).UserId
+	if err := userCollection.FindOne(ctx, bson.D{{Key: "id", Value: userId}}).Decode(&user); err != nil {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "User with specified ID not found!"}})
+	}
+
+	cursor, err := passkeyCollection.Find(ctx, bson.D{{Key: "userid", Value: userId}})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	var passkeys []models.Passkey
+	if err := cursor.All(ctx, &passkeys); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if len(passkeys) >= maxPasskeys {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Remove a passkey before adding another"}})
+	}
+
+	// the authenticator refuses to make a second passkey for the same account
+	exclude := []webauthn.CredentialDescriptor{}
+	for _, passkey := range passkeys {
+		id, err := decodeBase64URL(passkey.CredentialId)
+		if err == nil {
+			exclude = append(exclude, webauthn.CredentialDescriptor{Type: "public-key", Id: id, Transports: passkey.Transports})
+		}
+	}
+
+	challenge, err := issueToken(ctx, userId, models.TokenPasskeyCreate, "", passkeyChallengeTTL)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	opts := relyingParty.CreationOptions(challenge, userId[:], user.Email, user.Name, exclude)
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": opts}})
+}
+
+// FinishPasskeyRegistration stores the passkey the browser made with the options
+func FinishPasskeyRegistration(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	var req models.PasskeyRegisterReq
+	defer cancel()
+
+	if !relyingParty.Enabled() {
+		return passkeysDisabled(c)
+	}
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": validationErr.Error()}})
+	}
+
+	clientDataJSON, err := decodeBase64URL(req.ClientDataJSON)
+	var attestationObject []byte
+	if err == nil {
+		attestationObject, err = decodeBase64URL(req.AttestationObject)
+	}
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Credential is not base64url encoded"}})
+	}
+
+	userId := authFrom(c).UserId
+	challenge, err := webauthn.Challenge(clientDataJSON)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	token, err := consumeToken(ctx, challenge, models.TokenPasskeyCreate)
+	if err != nil || token.UserId != userId {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Passkey challenge is invalid or expired"}})
+	}
+
+	credential, err := relyingParty.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	transports := req.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+	passkey := models.Passkey{
+		CredentialId: base64.RawURLEncoding.EncodeToString(credential.Id),
+		UserId:       userId,
+		Name:         name,
+		PublicKey:    credential.PublicKey,
+		SignCount:    int64(credential.SignCount),
+		AAGUID:       hex.EncodeToString(credential.AAGUID),
+		Transports:   transports,
+		CreatedAt:    time.Now().UnixMilli(),
+	}
+	if _, err := passkeyCollection.InsertOne(ctx, passkey); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(http.StatusConflict).JSON(responses.UserResponse{Status: http.StatusConflict, Message: "error", Data: &fiber.Map{"data": "Passkey is already registered"}})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusCreated).JSON(responses.UserResponse{Status: http.StatusCreated, Message: "success", Data: &fiber.Map{"data": passkey}})
+}
+
+func GetPasskeys(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}})
+	cursor, err := passkeyCollection.Find(ctx, bson.D{{Key: "userid", Value: authFrom(c).UserId}}, opts)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	passkeys := []models.Passkey{}
+	if err := cursor.All(ctx, &passkeys); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": passkeys}})
+}
+
+func DeletePasskey(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "credentialid", Value: c.Params("credentialId")}, {Key: "userid", Value: authFrom(c).UserId}}
+	result, err := passkeyCollection.DeleteOne(ctx, filter)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if result.DeletedCount < 1 {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "Passkey not found"}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "Passkey removed"}})
+}
+
+// BeginPasskeySignIn returns the options to sign in with any passkey of this server
+func BeginPasskeySignIn(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if !relyingParty.Enabled() {
+		return passkeysDisabled(c)
+	}
+
+	// who signs in is only known from the passkey they pick
+	challenge, err := issueToken(ctx, primitive.NilObjectID, models.TokenPasskeyGet, "", passkeyChallengeTTL)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": relyingParty.RequestOptions(challenge)}})
+}
+
+// FinishPasskeySignIn signs in with the passkey the browser used. A passkey that checked who
+// the user is, with a PIN or biometrics, counts as both factors.
+func FinishPasskeySignIn(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	var req models.PasskeySignInReq
+	var passkey models.Passkey
+	var user models.User
+	defer cancel()
+
+	if !relyingParty.Enabled() {
+		return passkeysDisabled(c)
+	}
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": validationErr.Error()}})
+	}
+
+	clientDataJSON, err := decodeBase64URL(req.ClientDataJSON)
+	var authenticatorData, signature, userHandle []byte
+	if err == nil {
+		authenticatorData, err = decodeBase64URL(req.AuthenticatorData)
+	}
+	if err == nil {
+		signature, err = decodeBase64URL(req.Signature)
+	}
+	if err == nil {
+		userHandle, err = decodeBase64URL(req.UserHandle)
+	}
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Assertion is not base64url encoded"}})
+	}
+
+	challenge, err := webauthn.Challenge(clientDataJSON)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if _, err := consumeToken(ctx, challenge, models.TokenPasskeyGet); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Passkey challenge is invalid or expired"}})
+	}
+
+	if err := passkeyCollection.FindOne(ctx, bson.D{{Key: "credentialid", Value: req.CredentialId}}).Decode(&passkey); err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(responses.UserResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Passkey is not registered"}})
+	}
+	// the authenticator says which user the passkey was made for, it has to be its owner
+	if len(userHandle) > 0 && !bytes.Equal(userHandle, passkey.UserId[:]) {
+		return c.Status(http.StatusUnauthorized).JSON(responses.UserResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Passkey is not registered"}})
+	}
+
+	assertion, err := relyingParty.VerifyAssertion(challenge, passkey.PublicKey, uint32(passkey.SignCount), clientDataJSON, authenticatorData, signature)
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(responses.UserResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	// two sign ins with the same count at once means one of them replayed it
+	filter := bson.D{{Key: "credentialid", Value: passkey.CredentialId}, {Key: "signcount", Value: passkey.SignCount}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "signcount", Value: int64(assertion.SignCount)}, {Key: "lastusedat", Value: time.Now().UnixMilli()}}}}
+	result, err := passkeyCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if result.MatchedCount < 1 {
+		return c.Status(http.StatusUnauthorized).JSON(responses.UserResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Passkey was used by another sign in at the same time"}})
+	}
+
+	filter = bson.D{{Key: "id", Value: passkey.UserId}, {Key: "deleting", Value: bson.D{{Key: "$ne", Value: true}}}}
+	if err := userCollection.FindOne(ctx, filter).Decode(&user); err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(responses.UserResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Passkey is not registered"}})
+	}
+
+	if assertion.UserVerified {
+		return finishSignIn(ctx, c, user)
+	}
+	return continueSignIn(ctx, c, user)
+}
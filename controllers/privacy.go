@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// privacyViewer is who profiles are shown to, with their contacts to check "contacts"
+// settings against. A zero id is an anonymous viewer who only passes "everyone".
+type privacyViewer struct {
+	id       primitive.ObjectID
+	contacts map[primitive.ObjectID]bool
+}
+
+func newPrivacyViewer(ctx context.Context, viewerId primitive.ObjectID) (*privacyViewer, error) {
+	if viewerId.IsZero() {
+		return privacyViewerWith(viewerId, nil), nil
+	}
+
+	ids, err := contactIds(ctx, viewerId)
+	if err != nil {
+		return nil, err
+	}
+	return privacyViewerWith(viewerId, ids), nil
+}
+
+// privacyViewerWith is for callers that already loaded the viewer's contacts
+func privacyViewerWith(viewerId primitive.ObjectID, contactIds []primitive.ObjectID) *privacyViewer {
+	viewer := &privacyViewer{id: viewerId, contacts: make(map[primitive.ObjectID]bool, len(contactIds))}
+	for _, id := range contactIds {
+		viewer.contacts[id] = true
+	}
+	return viewer
+}
+
+func (viewer *privacyViewer) relation(userId primitive.ObjectID) (bool, bool) {
+	self := !viewer.id.IsZero() && viewer.id == userId
+	return self, viewer.contacts[userId]
+}
+
+func (viewer *privacyViewer) userInfo(info *models.UserInfo) {
+	info.ApplyPrivacy(viewer.relation(info.Id))
+}
+
+func (viewer *privacyViewer) publicUser(public *models.PublicUser, privacy models.PrivacySettings) {
+	self, contact := viewer.relation(public.Id)
+	public.ApplyPrivacy(privacy, self, contact)
+}
+
+// contact hides when the contact was last seen, and so if they are online, unless their
+// settings allow the viewer
+func (viewer *privacyViewer) contact(contact *models.ContactRes, privacy models.PrivacySettings) {
+	viewer.userInfo(&contact.UserInfo)
+	if !privacy.LastSeen.Allows(viewer.relation(contact.Id)) {
+		contact.Online = false
+		contact.LastSeenAt = 0
+	}
+}
+
+// splitGroupAdds separates the users the actor may add to a group straight away from those
+// whose settings ask for an invite instead
+func splitGroupAdds(ctx context.Context, actorId primitive.ObjectID, userIds []primitive.ObjectID) ([]primitive.ObjectID, []primitive.ObjectID, error) {
+	if len(userIds) == 0 {
+		return userIds, nil, nil
+	}
+
+	viewer, err := newPrivacyViewer(ctx, actorId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cursor, err := userCollection.Find(ctx, bson.D{{Key: "id", Value: bson.D{{Key: "$in", Value: userIds}}}})
+	if err != nil {
+		return nil, nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, nil, err
+	}
+	privacy := map[primitive.ObjectID]models.PrivacyLevel{}
+	for _, user := range users {
+		privacy[user.Id] = user.Privacy.GroupAdds
+	}
+
+	add := []primitive.ObjectID{}
+	invite := []primitive.ObjectID{}
+	for _, id := range userIds {
+		if privacy[id].Allows(viewer.relation(id)) {
+			add = append(add, id)
+		} else {
+			invite = append(invite, id)
+		}
+	}
+	return add, invite, nil
+}
+
+// sendGroupInvites invites users to a group. Inviting someone who already has an invite to
+// the same group keeps the first one.
+func sendGroupInvites(ctx context.Context, chatId primitive.ObjectID, chatName string, fromId primitive.ObjectID, toIds []primitive.ObjectID) error {
+	now := time.Now().UnixMilli()
+	for _, toId := range toIds {
+		invite := models.GroupInvite{
+			InviteId:  primitive.NewObjectID(),
+			ChatId:    chatId,
+			ChatName:  chatName,
+			FromId:    fromId,
+			ToId:      toId,
+			CreatedAt: now,
+		}
+		if err := upsertGroupInvite(ctx, invite); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/passwords"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var sessionCollection *mongo.Collection = configs.GetCollection(configs.DB, "sessions")
+var tokenCollection *mongo.Collection = configs.GetCollection(configs.DB, "tokens")
+
+// how long a session stays valid after sign in
+var sessionTTL = time.Duration(configs.GetEnvInt("SESSION_TTL_HOURS", 720)) * time.Hour
+
+var errInvalidToken = errors.New("token is invalid or expired")
+
+// a session this young stands in for the password when changing how the account signs in
+const freshSignInWindow = 5 * time.Minute
+
+// the answer when a change to how the account signs in lacks the password or a fresh sign in
+const errReauthenticate = "Confirm with your current password, or sign in again"
+
+// createSession signs a user in on a new device and returns its bearer token
+func createSession(ctx context.Context, userId primitive.ObjectID) (string, error) {
+	token, err := models.NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		SessionId: primitive.NewObjectID(),
+		UserId:    userId,
+		TokenHash: models.HashToken(token),
+		CreatedAt: now.UnixMilli(),
+		ExpiresAt: now.Add(sessionTTL).UnixMilli(),
+	}
+
+	if _, err := sessionCollection.InsertOne(ctx, session); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// sessionFromRequest finds the live session of the request's "Authorization: Bearer" token
+func sessionFromRequest(ctx context.Context, c *fiber.Ctx) (*models.Session, error) {
+	token := strings.TrimSpace(strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "))
+	if token == "" {
+		return nil, errInvalidToken
+	}
+
+	filter := bson.D{
+		{Key: "tokenhash", Value: models.HashToken(token)},
+		{Key: "expiresat", Value: bson.D{{Key: "$gt", Value: time.Now().UnixMilli()}}},
+	}
+
+	var session models.Session
+	if err := sessionCollection.FindOne(ctx, filter).Decode(&session); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errInvalidToken
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+// reauthenticated tells if a signed in request also proves it is the user, with their current
+// password or a session that signed in moments ago, for accounts without a password
+func reauthenticated(ctx context.Context, c *fiber.Ctx, user *models.User, password string) (bool, error) {
+	if password != "" {
+		return user.Password != "" && passwords.Verify(password, user.Password) == nil, nil
+	}
+
+	session, err := sessionFromRequest(ctx, c)
+	if err == errInvalidToken {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return session.UserId == user.Id && time.Since(time.UnixMilli(session.CreatedAt)) < freshSignInWindow, nil
+}
+
+// revokeSessions signs a user out everywhere except the kept session, if any
+func revokeSessions(ctx context.Context, userId primitive.ObjectID, keep primitive.ObjectID) error {
+	filter := bson.D{{Key: "userid", Value: userId}}
+	if !keep.IsZero() {
+		filter = append(filter, bson.E{Key: "sessionid", Value: bson.D{{Key: "$ne", Value: keep}}})
+	}
+	_, err := sessionCollection.DeleteMany(ctx, filter)
+	return err
+}
+
+// issueToken stores a single use token for a user and returns it so it can be sent to them
+func issueToken(ctx context.Context, userId primitive.ObjectID, purpose models.TokenPurpose, email string, ttl time.Duration) (string, error) {
+	token, err := models.NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	record := models.OneTimeToken{
+		TokenHash: models.HashToken(token),
+		UserId:    userId,
+		Purpose:   purpose,
+		Email:     email,
+		CreatedAt: time.Now().UnixMilli(),
+		ExpiresAt: time.Now().Add(ttl).UnixMilli(),
+		Used:      false,
+	}
+
+	if _, err := tokenCollection.InsertOne(ctx, record); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeToken marks a token used and returns it. A token works once, and only before it expires.
+func consumeToken(ctx context.Context, token string, purpose models.TokenPurpose) (*models.OneTimeToken, error) {
+	filter := bson.D{
+		{Key: "tokenhash", Value: models.HashToken(token)},
+		{Key: "purpose", Value: purpose},
+		{Key: "used", Value: false},
+		{Key: "expiresat", Value: bson.D{{Key: "$gt", Value: time.Now().UnixMilli()}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "used", Value: true}}}}
+
+	var record models.OneTimeToken
+	if err := tokenCollection.FindOneAndUpdate(ctx, filter, update).Decode(&record); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errInvalidToken
+		}
+		return nil, err
+	}
+	return &record, nil
+}
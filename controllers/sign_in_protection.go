@@ -0,0 +1,261 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+	"github.com/achintya-7/go-fiber-chat/mailer"
+	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/passwords"
+	"github.com/achintya-7/go-fiber-chat/responses"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var signInCounterCollection *mongo.Collection = configs.GetCollection(configs.DB, "sign_in_counters")
+var auditCollection *mongo.Collection = configs.GetCollection(configs.DB, "audit_logs")
+
+// failed sign ins before an email or an IP address is locked out. The first half of them are
+// free, after that every failure doubles the wait before the next try.
+var maxAccountSignInFailures = configs.GetEnvInt("SIGN_IN_MAX_FAILURES", 5)
+var maxIPSignInFailures = configs.GetEnvInt("SIGN_IN_MAX_IP_FAILURES", 50)
+var signInLockout = time.Duration(configs.GetEnvInt("SIGN_IN_LOCKOUT_MINUTES", 15)) * time.Minute
+
+// failures older than this are forgotten
+const signInFailureWindow = time.Hour
+
+// the longest wait between tries before a lockout
+const maxSignInDelay = 30 * time.Second
+
+// how long the code in a lockout email stays valid
+const accountUnlockTTL = 24 * time.Hour
+
+// errSignInFailed is the only answer to a wrong email or password, so sign in cant be used to
+// find out which emails have an account
+const errSignInFailed = "Invalid email or password"
+
+// dummyPasswordHash is compared against when the email has no account, so that takes as long
+// as a wrong password. It is made under the current policy the first time it is needed.
+var dummyHash string
+var dummyHashOnce sync.Once
+
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		var err error
+		if dummyHash, err = passwords.Hash("no account has this password"); err != nil {
+			log.Print(err)
+		}
+	})
+	return dummyHash
+}
+
+// rehashPassword stores the password hashed under the current policy
+func rehashPassword(ctx context.Context, user *models.User, password string) error {
+	hash, err := passwords.Hash(password)
+	if err != nil {
+		return err
+	}
+	// only if the hash is still the one that was checked, a password change in between wins
+	filter := bson.D{{Key: "id", Value: user.Id}, {Key: "password", Value: user.Password}}
+	if _, err := userCollection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: bson.D{{Key: "password", Value: hash}}}}); err != nil {
+		return err
+	}
+	user.Password = hash
+	return nil
+}
+
+func accountCounterKey(email string) string {
+	return "account:" + email
+}
+
+func ipCounterKey(ip string) string {
+	return "ip:" + ip
+}
+
+// signInDelay is how long to wait after a number of failures, out of a limit
+func signInDelay(failures int, limit int) time.Duration {
+	over := failures - limit/2
+	if over <= 0 {
+		return 0
+	}
+	if over > 6 {
+		return maxSignInDelay
+	}
+	delay := time.Second << (over - 1)
+	if delay > maxSignInDelay {
+		return maxSignInDelay
+	}
+	return delay
+}
+
+// signInRetryAt tells when the email or IP address may try again, zero when it may now
+func signInRetryAt(ctx context.Context, email string, ip string) (time.Time, error) {
+	now := time.Now().UnixMilli()
+	filter := bson.D{{Key: "key", Value: bson.D{{Key: "$in", Value: bson.A{accountCounterKey(email), ipCounterKey(ip)}}}}}
+	cursor, err := signInCounterCollection.Find(ctx, filter)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var counters []models.SignInCounter
+	if err := cursor.All(ctx, &counters); err != nil {
+		return time.Time{}, err
+	}
+
+	var retryAt int64
+	for _, counter := range counters {
+		for _, until := range []int64{counter.RetryAt, counter.LockedUntil} {
+			if until > now && until > retryAt {
+				retryAt = until
+			}
+		}
+	}
+	if retryAt == 0 {
+		return time.Time{}, nil
+	}
+	return time.UnixMilli(retryAt), nil
+}
+
+// countSignInFailure adds a failure to a counter and sets the wait before the next try. It
+// tells if this failure locked the counter.
+func countSignInFailure(ctx context.Context, key string, limit int) (bool, error) {
+	now := time.Now()
+	nowMillis := now.UnixMilli()
+
+	// failures start over once they are old or a lockout has passed
+	stale := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "$lt", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$lastfailureat", 0}}}, now.Add(-signInFailureWindow).UnixMilli()}}},
+		bson.D{{Key: "$and", Value: bson.A{
+			bson.D{{Key: "$gt", Value: bson.A{"$lockeduntil", 0}}},
+			bson.D{{Key: "$lte", Value: bson.A{"$lockeduntil", nowMillis}}},
+		}}},
+	}}}
+	update := bson.A{bson.D{{Key: "$set", Value: bson.D{
+		{Key: "key", Value: key},
+		{Key: "failures", Value: bson.D{{Key: "$cond", Value: bson.A{stale, 1, bson.D{{Key: "$add", Value: bson.A{"$failures", 1}}}}}}},
+		{Key: "lockeduntil", Value: bson.D{{Key: "$cond", Value: bson.A{stale, 0, bson.D{{Key: "$ifNull", Value: bson.A{"$lockeduntil", 0}}}}}}},
+		{Key: "lastfailureat", Value: nowMillis},
+	}}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter models.SignInCounter
+	if err := signInCounterCollection.FindOneAndUpdate(ctx, bson.D{{Key: "key", Value: key}}, update, opts).Decode(&counter); err != nil {
+		return false, err
+	}
+
+	set := bson.D{{Key: "retryat", Value: now.Add(signInDelay(counter.Failures, limit)).UnixMilli()}}
+	locked := counter.Failures >= limit && counter.LockedUntil == 0
+	if locked {
+		set = append(set, bson.E{Key: "lockeduntil", Value: now.Add(signInLockout).UnixMilli()})
+	}
+	_, err := signInCounterCollection.UpdateOne(ctx, bson.D{{Key: "key", Value: key}}, bson.D{{Key: "$set", Value: set}})
+	return locked, err
+}
+
+// recordSignInFailure counts a failed sign in for the email and the IP address and keeps an
+// audit record of it. When the account gets locked its owner is mailed a way to unlock it.
+func recordSignInFailure(ctx context.Context, c *fiber.Ctx, email string, user *models.User) error {
+	entry := models.AuditLog{Event: models.AuditSignInFailed, Email: email, IP: c.IP(), UserAgent: c.Get(fiber.HeaderUserAgent)}
+	if user != nil {
+		entry.UserId = user.Id
+	}
+	if err := audit(ctx, entry); err != nil {
+		return err
+	}
+
+	if _, err := countSignInFailure(ctx, ipCounterKey(c.IP()), maxIPSignInFailures); err != nil {
+		return err
+	}
+	locked, err := countSignInFailure(ctx, accountCounterKey(email), maxAccountSignInFailures)
+	if err != nil || !locked {
+		return err
+	}
+
+	entry.Event = models.AuditAccountLocked
+	if err := audit(ctx, entry); err != nil {
+		return err
+	}
+	if user != nil {
+		unlocked := *user
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := sendUnlockEmail(ctx, &unlocked); err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+	return nil
+}
+
+// clearSignInFailures forgets the failures of an email after it signed in. The IP address
+// keeps its count, one good account shouldnt clear guesses at others.
+func clearSignInFailures(ctx context.Context, email string) error {
+	_, err := signInCounterCollection.DeleteOne(ctx, bson.D{{Key: "key", Value: accountCounterKey(email)}})
+	return err
+}
+
+func sendUnlockEmail(ctx context.Context, user *models.User) error {
+	token, err := issueToken(ctx, user.Id, models.TokenAccountUnlock, user.Email, accountUnlockTTL)
+	if err != nil {
+		return err
+	}
+	body := "There were too many failed attempts to sign in to your account, so signing in is paused for a while. " +
+		"If that was you, use this code to unlock it now: " + token + "\n\nIf it wasnt you, consider changing your password."
+	return mailer.Default.Send(user.Email, "Your account was locked", body)
+}
+
+func audit(ctx context.Context, entry models.AuditLog) error {
+	entry.AuditId = primitive.NewObjectID()
+	entry.CreatedAt = time.Now().UnixMilli()
+	_, err := auditCollection.InsertOne(ctx, entry)
+	return err
+}
+
+// tooManySignIns answers a sign in that came before the wait after earlier failures was over
+func tooManySignIns(c *fiber.Ctx, retryAt time.Time) error {
+	seconds := int(time.Until(retryAt).Seconds()) + 1
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return c.Status(http.StatusTooManyRequests).JSON(responses.UserResponse{
+		Status:  http.StatusTooManyRequests,
+		Message: "Too many sign in attempts, try again later",
+		Data:    &fiber.Map{"data": &fiber.Map{}},
+	})
+}
+
+// UnlockAccount lifts a lockout with the code from the lockout email
+func UnlockAccount(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	var req models.VerifyTokenReq
+	defer cancel()
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": validationErr.Error()}})
+	}
+
+	token, err := consumeToken(ctx, req.Token, models.TokenAccountUnlock)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": errInvalidToken.Error()}})
+	}
+
+	if err := clearSignInFailures(ctx, token.Email); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	entry := models.AuditLog{Event: models.AuditAccountUnlocked, UserId: token.UserId, Email: token.Email, IP: c.IP(), UserAgent: c.Get(fiber.HeaderUserAgent)}
+	if err := audit(ctx, entry); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "Account unlocked"}})
+}
@@ -0,0 +1,266 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/responses"
+	"github.com/achintya-7/go-fiber-chat/totp"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// the name authenticator apps show next to the account
+var totpIssuer = totpIssuerFromEnv()
+
+func totpIssuerFromEnv() string {
+	if issuer := configs.GetEnv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "go-fiber-chat"
+}
+
+// how long the second step of a sign in can take, and how many codes can be tried in it
+const twoFactorChallengeTTL = 5 * time.Minute
+const maxTwoFactorAttempts = 5
+
+const recoveryCodeCount = 10
+
+// EnrollTwoFactor starts setting up an authenticator app, for a user who confirmed it is them.
+// Two factor sign in is only turned on once ConfirmTwoFactor receives a first code, so a half
+// finished setup cant lock anyone out.
+func EnrollTwoFactor(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	var req models.TwoFactorEnrollReq
+	var user models.User
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(c.Params("userId"))
+
+	// the body is optional for sessions that signed in moments ago
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+		}
+	}
+
+	if err := userCollection.FindOne(ctx, bson.D{{Key: "id", Value: objId}}).Decode(&user); err != nil {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "User with specified ID not found!"}})
+	}
+	confirmed, err := reauthenticated(ctx, c, &user, req.CurrentPassword)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if !confirmed {
+		return c.Status(http.StatusForbidden).JSON(responses.UserResponse{Status: http.StatusForbidden, Message: "error", Data: &fiber.Map{"data": errReauthenticate}})
+	}
+	if user.TwoFactor.Enabled {
+		return c.Status(http.StatusConflict).JSON(responses.UserResponse{Status: http.StatusConflict, Message: "error", Data: &fiber.Map{"data": "Two factor sign in is already on"}})
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "twofactor.pendingsecret", Value: secret}}}}
+	if _, err := userCollection.UpdateOne(ctx, bson.D{{Key: "id", Value: objId}}, update); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	res := models.TwoFactorEnrollRes{Secret: secret, URI: totp.URI(totpIssuer, user.Email, secret)}
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": res}})
+}
+
+// ConfirmTwoFactor turns two factor sign in on with a first code from the app, and returns
+// the recovery codes. They are only stored hashed, so this is the one time they are shown.
+func ConfirmTwoFactor(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	var req models.TwoFactorCodeReq
+	var user models.User
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(c.Params("userId"))
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": validationErr.Error()}})
+	}
+
+	if err := userCollection.FindOne(ctx, bson.D{{Key: "id", Value: objId}}).Decode(&user); err != nil {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "User with specified ID not found!"}})
+	}
+	if user.TwoFactor.PendingSecret == "" {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Start two factor enrollment first"}})
+	}
+
+	counter, ok := totp.Validate(user.TwoFactor.PendingSecret, req.Code, time.Now())
+	if !ok {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Invalid code"}})
+	}
+
+	codes, err := models.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, models.HashToken(models.NormalizeRecoveryCode(code)))
+	}
+
+	twoFactor := models.TwoFactor{
+		Enabled:       true,
+		Secret:        user.TwoFactor.PendingSecret,
+		LastCounter:   int64(counter),
+		RecoveryCodes: hashes,
+	}
+	// the pending secret must not have been replaced by another enrollment in the meantime
+	filter := bson.D{{Key: "id", Value: objId}, {Key: "twofactor.pendingsecret", Value: user.TwoFactor.PendingSecret}}
+	result, err := userCollection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: bson.D{{Key: "twofactor", Value: twoFactor}}}})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if result.MatchedCount < 1 {
+		return c.Status(http.StatusConflict).JSON(responses.UserResponse{Status: http.StatusConflict, Message: "error", Data: &fiber.Map{"data": "Enrollment was restarted, confirm with the new secret"}})
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": fiber.Map{"recoveryCodes": codes}}})
+}
+
+// DisableTwoFactor turns two factor sign in off, with a code from the app or a recovery code
+// and the current password or a fresh sign in
+func DisableTwoFactor(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	var req models.TwoFactorDisableReq
+	var user models.User
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(c.Params("userId"))
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if err := userCollection.FindOne(ctx, bson.D{{Key: "id", Value: objId}}).Decode(&user); err != nil {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "User with specified ID not found!"}})
+	}
+	if !user.TwoFactor.Enabled {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Two factor sign in is not on"}})
+	}
+	confirmed, err := reauthenticated(ctx, c, &user, req.CurrentPassword)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if !confirmed {
+		return c.Status(http.StatusForbidden).JSON(responses.UserResponse{Status: http.StatusForbidden, Message: "error", Data: &fiber.Map{"data": errReauthenticate}})
+	}
+
+	ok, err := checkSecondFactor(ctx, &user, req.Code, req.RecoveryCode)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if !ok {
+		return c.Status(http.StatusForbidden).JSON(responses.UserResponse{Status: http.StatusForbidden, Message: "error", Data: &fiber.Map{"data": "Invalid code"}})
+	}
+
+	if _, err := userCollection.UpdateOne(ctx, bson.D{{Key: "id", Value: objId}}, bson.D{{Key: "$unset", Value: bson.D{{Key: "twofactor", Value: ""}}}}); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "Two factor sign in turned off"}})
+}
+
+// VerifyTwoFactorSignIn is the second step of signing in, trading the challenge from
+// SignInUser and a code for a session
+func VerifyTwoFactorSignIn(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	var req models.TwoFactorSignInReq
+	var user models.User
+	defer cancel()
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": validationErr.Error()}})
+	}
+
+	// every try counts against the challenge, so its codes cant be guessed one after another
+	filter := bson.D{
+		{Key: "tokenhash", Value: models.HashToken(req.Challenge)},
+		{Key: "purpose", Value: models.TokenSignIn2FA},
+		{Key: "used", Value: false},
+		{Key: "expiresat", Value: bson.D{{Key: "$gt", Value: time.Now().UnixMilli()}}},
+		{Key: "attempts", Value: bson.D{{Key: "$lt", Value: maxTwoFactorAttempts}}},
+	}
+	update := bson.D{{Key: "$inc", Value: bson.D{{Key: "attempts", Value: 1}}}}
+	var challenge models.OneTimeToken
+	if err := tokenCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&challenge); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(http.StatusUnauthorized).JSON(responses.UserResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Sign in again"}})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	userFilter := bson.D{{Key: "id", Value: challenge.UserId}, {Key: "deleting", Value: bson.D{{Key: "$ne", Value: true}}}}
+	if err := userCollection.FindOne(ctx, userFilter).Decode(&user); err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(responses.UserResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Sign in again"}})
+	}
+
+	ok, err := checkSecondFactor(ctx, &user, req.Code, req.RecoveryCode)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if !ok {
+		return c.Status(http.StatusUnauthorized).JSON(responses.UserResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Invalid code"}})
+	}
+
+	if _, err := consumeToken(ctx, req.Challenge, models.TokenSignIn2FA); err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(responses.UserResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Sign in again"}})
+	}
+
+	return finishSignIn(ctx, c, user)
+}
+
+// checkSecondFactor accepts a code from the app, or else a recovery code. Either works once:
+// app codes must be newer than the last one accepted, and recovery codes are removed when used.
+func checkSecondFactor(ctx context.Context, user *models.User, code string, recoveryCode string) (bool, error) {
+	if !user.TwoFactor.Enabled {
+		return false, nil
+	}
+
+	var filter, update bson.D
+	switch {
+	case code != "":
+		counter, ok := totp.Validate(user.TwoFactor.Secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		filter = bson.D{{Key: "id", Value: user.Id}, {Key: "twofactor.lastcounter", Value: bson.D{{Key: "$lt", Value: int64(counter)}}}}
+		update = bson.D{{Key: "$set", Value: bson.D{{Key: "twofactor.lastcounter", Value: int64(counter)}}}}
+	case recoveryCode != "":
+		hash := models.HashToken(models.NormalizeRecoveryCode(recoveryCode))
+		filter = bson.D{{Key: "id", Value: user.Id}, {Key: "twofactor.recoverycodes", Value: hash}}
+		update = bson.D{{Key: "$pull", Value: bson.D{{Key: "twofactor.recoverycodes", Value: hash}}}}
+	default:
+		return false, nil
+	}
+
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
@@ -3,16 +3,23 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"log"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/achintya-7/go-fiber-chat/configs"
+	"github.com/achintya-7/go-fiber-chat/mailer"
 	"github.com/achintya-7/go-fiber-chat/models"
+	"github.com/achintya-7/go-fiber-chat/passwords"
 	"github.com/achintya-7/go-fiber-chat/responses"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -20,6 +27,15 @@ var userCollection *mongo.Collection = configs.GetCollection(configs.DB, "users"
 
 var validate = validator.New()
 
+// how long the code confirming a new email stays valid
+const emailChangeTTL = 24 * time.Hour
+
+const maxBioLength = 300
+const maxStatusLength = 140
+
+const defaultUsersLimit = 20
+const maxUsersLimit = 50
+
 func CreateUser(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	var user models.User
@@ -33,20 +49,42 @@ func CreateUser(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": validationErr.Error()}})
 	}
 
+	user.Email = models.NormalizeEmail(user.Email)
+	if !models.ValidEmail(user.Email) {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Email is not a valid address"}})
+	}
+
+	if err := passwords.Check(user.Password); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	hash, err := passwords.Hash(user.Password)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
 	newUser := models.User{
 		Id:       primitive.NewObjectID(),
 		Name:     user.Name,
 		Email:    user.Email,
-		Password: models.SetPassword2(user.Password),
+		Password: hash,
 	}
+	newUser.SetSearchKeys()
 
 	result, err := userCollection.InsertOne(ctx, newUser)
+	if mongo.IsDuplicateKeyError(err) {
+		return c.Status(http.StatusConflict).JSON(responses.UserResponse{Status: http.StatusConflict, Message: "error", Data: &fiber.Map{"data": "Email is already in use"}})
+	}
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 
 	}
 
-	return c.Status(http.StatusCreated).JSON(responses.UserResponse{Status: http.StatusCreated, Message: "success", Data: &fiber.Map{"data": newUser, "result": result}})
+	// the account is created either way, a failed email can be sent again with the resend endpoint
+	if err := sendVerificationEmail(ctx, &newUser); err != nil {
+		log.Print(err)
+	}
+
+	return c.Status(http.StatusCreated).JSON(responses.UserResponse{Status: http.StatusCreated, Message: "success", Data: &fiber.Map{"data": models.ToSelfUser(newUser), "result": result}})
 }
 
 func SignInUser(c *fiber.Ctx) error {
@@ -63,24 +101,102 @@ func SignInUser(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": validationErr.Error()}})
 	}
 
-	result := userCollection.FindOne(ctx, bson.M{"email": user.Email})
-	if result.Err() != nil {
+	email := models.NormalizeEmail(user.Email)
+
+	retryAt, err := signInRetryAt(ctx, email, c.IP())
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+	if !retryAt.IsZero() {
+		return tooManySignIns(c, retryAt)
+	}
+
+	// a missing account and a wrong password look the same from outside, down to how long they take
+	var found *models.User
+	err = userCollection.FindOne(ctx, bson.M{"email": email, "deleting": bson.M{"$ne": true}}).Decode(&newUser)
+	switch err {
+	case nil:
+		found = &newUser
+		err = passwords.Verify(user.Password, newUser.Password)
+	case mongo.ErrNoDocuments:
+		passwords.Verify(user.Password, dummyPasswordHash())
+	default:
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+			Status:  http.StatusInternalServerError,
+			Message: err.Error(),
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	if err != nil {
+		if err := recordSignInFailure(ctx, c, email, found); err != nil {
+			log.Print(err)
+		}
+		return c.Status(http.StatusUnauthorized).JSON(responses.UserResponse{
+			Status:  http.StatusUnauthorized,
+			Message: errSignInFailed,
+			Data:    &fiber.Map{"data": &fiber.Map{}},
+		})
+	}
+
+	if err := clearSignInFailures(ctx, email); err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
 			Status:  http.StatusInternalServerError,
-			Message: "No user was found",
+			Message: err.Error(),
 			Data:    &fiber.Map{"data": &fiber.Map{}},
 		})
 	}
 
-	result.Decode(&newUser)
+	// hashes made under an older policy are replaced while the password is at hand
+	if passwords.NeedsRehash(newUser.Password) {
+		if err := rehashPassword(ctx, &newUser, user.Password); err != nil {
+			log.Print(err)
+		}
+	}
+
+	return continueSignIn(ctx, c, newUser)
+}
+
+// continueSignIn takes a user who passed the first step of signing in. With two factor sign in
+// on, that only earns a challenge for the second step.
+func continueSignIn(ctx context.Context, c *fiber.Ctx, user models.User) error {
+	if user.TwoFactor.Enabled {
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		challenge, err := issueToken(ctx, user.Id, models.TokenSignIn2FA, "", twoFactorChallengeTTL)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
+				Status:  http.StatusInternalServerError,
+				Message: err.Error(),
+				Data:    &fiber.Map{"data": &fiber.Map{}},
+			})
+		}
+		return c.Status(http.StatusOK).JSON(responses.UserResponse{
+			Status:  http.StatusOK,
+			Message: "Two factor code required",
+			Data:    &fiber.Map{"data": &fiber.Map{"challenge": challenge}},
+		})
+	}
+
+	return finishSignIn(ctx, c, user)
+}
 
-	fmt.Println(string(user.Password))
-	fmt.Println(string(newUser.Password))
+// finishSignIn starts a session for a user who proved who they are
+func finishSignIn(ctx context.Context, c *fiber.Ctx, user models.User) error {
+	// no cache along the way may keep the session token
+	c.Set(fiber.HeaderCacheControl, "no-store")
 
-	if err := models.ComparePassword2(user.Password, newUser.Password); err != nil {
+	token, err := createSession(ctx, user.Id)
+	if err == nil {
+		err = touchPresence(ctx, user.Id)
+	}
+	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{
 			Status:  http.StatusInternalServerError,
-			Message: "Wrong Password",
+			Message: err.Error(),
 			Data:    &fiber.Map{"data": &fiber.Map{}},
 		})
 	}
@@ -88,7 +204,7 @@ func SignInUser(c *fiber.Ctx) error {
 	return c.Status(200).JSON(responses.UserResponse{
 		Status:  200,
 		Message: "Sign In Succesfully",
-		Data:    &fiber.Map{"data": newUser},
+		Data:    &fiber.Map{"data": models.ToSelfUser(user), "token": token},
 	})
 
 }
@@ -107,91 +223,451 @@ func GetAUser(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
 
-	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": user}})
+	// someone who blocked the viewer only shows their name
+	viewerId := authFrom(c).UserId
+	if viewerId != objId {
+		blocking, err := findBlockingUsers(ctx, viewerId, []primitive.ObjectID{objId})
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+		}
+		if len(blocking) > 0 {
+			return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": models.UserInfo{Id: user.Id, Name: user.Name}}})
+		}
+	}
+
+	// photo and bio are only shown to who the user's privacy settings allow
+	viewer, err := newPrivacyViewer(ctx, viewerId)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	public := models.ToPublicUser(user)
+	viewer.publicUser(&public, user.Privacy)
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": public}})
 }
 
-func EditAUser(c *fiber.Ctx) error {
+// UpdateUser changes only the profile fields that are sent. Changing the email takes the
+// current password, and the new email is not used until the user confirms it with the token
+// mailed to that address.
+func UpdateUser(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	userId := c.Params("userId")
+	var req models.UpdateUserReq
 	var user models.User
 	defer cancel()
 
 	objId, _ := primitive.ObjectIDFromHex(userId)
 
-	if err := c.BodyParser(&user); err != nil {
+	if err := c.BodyParser(&req); err != nil {
 		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
 
-	if validationErr := validate.Struct(&user); validationErr != nil {
-		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": validationErr.Error()}})
+	if err := userCollection.FindOne(ctx, bson.M{"id": objId}).Decode(&user); err != nil {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "User with specified ID not found!"}})
 	}
 
-	update := bson.M{"name": user.Name, "email": user.Email, "password": user.Password}
+	update := bson.M{}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Name cant be empty"}})
+		}
+		user.Name = name
+		user.SetSearchKeys()
+		update["name"] = user.Name
+		update["searchname"] = user.SearchName
+	}
 
-	result, err := userCollection.UpdateOne(ctx, bson.M{"id": objId}, bson.M{"$set": update})
+	if req.Handle != nil {
+		handle, ok := models.NormalizeHandle(*req.Handle)
+		if !ok {
+			return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "A handle is 3 to 30 letters, digits or underscores"}})
+		}
+		user.Handle = handle
+		user.SetSearchKeys()
+		update["handle"] = user.Handle
+		update["handlelower"] = user.HandleLower
+	}
+	if req.Bio != nil {
+		bio := strings.TrimSpace(*req.Bio)
+		if len([]rune(bio)) > maxBioLength {
+			return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": fmt.Sprintf("Bio can be at most %d characters", maxBioLength)}})
+		}
+		user.Bio = bio
+		update["bio"] = user.Bio
+	}
+	if req.StatusText != nil {
+		status := strings.TrimSpace(*req.StatusText)
+		if len([]rune(status)) > maxStatusLength {
+			return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": fmt.Sprintf("Status can be at most %d characters", maxStatusLength)}})
+		}
+		user.StatusText = status
+		user.StatusExpiresAt = 0
+		if req.StatusExpiresAt != nil {
+			user.StatusExpiresAt = *req.StatusExpiresAt
+		}
+		update["statustext"] = user.StatusText
+		update["statusexpiresat"] = user.StatusExpiresAt
+	}
 
-	if err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	if req.DmsFromContactsOnly != nil {
+		user.DmsFromContactsOnly = *req.DmsFromContactsOnly
+		update["dmsfromcontactsonly"] = user.DmsFromContactsOnly
 	}
 
-	var updatedUser models.User
-	if result.MatchedCount == 1 {
-		err := userCollection.FindOne(ctx, bson.M{"id": objId}).Decode(&updatedUser)
+	if req.Privacy != nil {
+		settings := []struct {
+			key     string
+			level   *models.PrivacyLevel
+			setting *models.PrivacyLevel
+		}{
+			{"privacy.lastseen", req.Privacy.LastSeen, &user.Privacy.LastSeen},
+			{"privacy.photo", req.Privacy.Photo, &user.Privacy.Photo},
+			{"privacy.bio", req.Privacy.Bio, &user.Privacy.Bio},
+			{"privacy.groupadds", req.Privacy.GroupAdds, &user.Privacy.GroupAdds},
+		}
+		for _, s := range settings {
+			if s.level == nil {
+				continue
+			}
+			if !s.level.Valid() {
+				return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Privacy settings can be everyone, contacts or nobody"}})
+			}
+			*s.setting = *s.level
+			update[s.key] = *s.level
+		}
+	}
 
+	emailPending := false
+	if req.Email != nil && models.NormalizeEmail(*req.Email) != user.Email {
+		// a stolen session alone cant move the account to another address
+		confirmed, err := reauthenticated(ctx, c, &user, req.CurrentPassword)
 		if err != nil {
 			return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 		}
+		if !confirmed {
+			return c.Status(http.StatusForbidden).JSON(responses.UserResponse{Status: http.StatusForbidden, Message: "error", Data: &fiber.Map{"data": errReauthenticate}})
+		}
+
+		email := models.NormalizeEmail(*req.Email)
+		if !models.ValidEmail(email) {
+			return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Email is not a valid address"}})
+		}
+
+		taken, err := userCollection.CountDocuments(ctx, bson.M{"email": email})
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+		}
+		if taken > 0 {
+			return c.Status(http.StatusConflict).JSON(responses.UserResponse{Status: http.StatusConflict, Message: "error", Data: &fiber.Map{"data": "Email is already in use"}})
+		}
+
+		token, err := issueToken(ctx, objId, models.TokenEmailChange, email, emailChangeTTL)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+		}
+		if err := mailer.Default.Send(email, "Confirm your new email", "Use this code to confirm your new email address: "+token); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+		}
+
+		update["pendingemail"] = email
+		emailPending = true
+	}
+
+	if len(update) == 0 {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Nothing to update"}})
+	}
+
+	if _, err := userCollection.UpdateOne(ctx, bson.M{"id": objId}, bson.M{"$set": update}); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(http.StatusConflict).JSON(responses.UserResponse{Status: http.StatusConflict, Message: "error", Data: &fiber.Map{"data": "Handle is already taken"}})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	message := "success"
+	if emailPending {
+		message = "Check your new email to confirm the change"
+	}
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: message, Data: &fiber.Map{"data": models.ToSelfUser(user)}})
+}
+
+// VerifyEmailChange switches a user to the pending email once they send back its token
+func VerifyEmailChange(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	userId := c.Params("userId")
+	var req models.VerifyTokenReq
+	var user models.User
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(userId)
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": validationErr.Error()}})
+	}
+
+	token, err := consumeToken(ctx, req.Token, models.TokenEmailChange)
+	if err != nil || token.UserId != objId {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": errInvalidToken.Error()}})
+	}
+
+	if err := userCollection.FindOne(ctx, bson.M{"id": objId}).Decode(&user); err != nil {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "User with specified ID not found!"}})
+	}
+
+	// the code was mailed to the new address, so it is verified too
+	oldEmail := user.Email
+	user.Email = token.Email
+	user.EmailVerified = true
+	user.SetSearchKeys()
+	update := bson.M{"$set": bson.M{"email": user.Email, "searchemail": user.SearchEmail, "emailverified": true}, "$unset": bson.M{"pendingemail": ""}}
+	if _, err := userCollection.UpdateOne(ctx, bson.M{"id": objId}, update); err != nil {
+		// someone else took the address after the change was asked for
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(http.StatusConflict).JSON(responses.UserResponse{Status: http.StatusConflict, Message: "error", Data: &fiber.Map{"data": "Email is already in use"}})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
 
-	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": updatedUser}})
+	// the old address hears about the change, in case it wasnt the owner who made it
+	go func() {
+		body := "The email of your account was changed to " + user.Email + ". If you did not do this, reset your password and contact support."
+		if err := mailer.Default.Send(oldEmail, "Your email was changed", body); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": models.ToSelfUser(user)}})
 }
 
+// ChangePassword sets a new password after checking the current one, or a fresh sign in for
+// accounts without one, and signs the user out of every other session
+func ChangePassword(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	userId := c.Params("userId")
+	var req models.ChangePasswordReq
+	var user models.User
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(userId)
+
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": validationErr.Error()}})
+	}
+
+	if err := userCollection.FindOne(ctx, bson.M{"id": objId}).Decode(&user); err != nil {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "User with specified ID not found!"}})
+	}
+
+	// accounts without a password can set one after signing in again
+	confirmed, err := reauthenticated(ctx, c, &user, req.CurrentPassword)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if !confirmed {
+		return c.Status(http.StatusForbidden).JSON(responses.UserResponse{Status: http.StatusForbidden, Message: "error", Data: &fiber.Map{"data": errReauthenticate}})
+	}
+
+	if err := passwords.Check(req.NewPassword); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	hash, err := passwords.Hash(req.NewPassword)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if _, err := userCollection.UpdateOne(ctx, bson.M{"id": objId}, bson.M{"$set": bson.M{"password": hash}}); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	// the session making this request stays signed in
+	keep := primitive.NilObjectID
+	if session, err := sessionFromRequest(ctx, c); err == nil && session.UserId == objId {
+		keep = session.SessionId
+	}
+	if err := revokeSessions(ctx, objId, keep); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "Password changed"}})
+}
+
+// DeleteAUser queues the deletion of an account. It takes the current password, or a session
+// that signed in moments ago, as there is no undoing it.
 func DeleteAUser(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	userId := c.Params("userId")
+	var req models.DeleteUserReq
+	var user models.User
 	defer cancel()
 
 	objId, _ := primitive.ObjectIDFromHex(userId)
 
-	result, err := userCollection.DeleteOne(ctx, bson.M{"id": objId})
+	// the body is optional for sessions that signed in moments ago
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+		}
+	}
 
+	if err := userCollection.FindOne(ctx, bson.M{"id": objId}).Decode(&user); err != nil {
+		return c.Status(http.StatusNotFound).JSON(responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "User with specified ID not found!"}})
+	}
+	confirmed, err := reauthenticated(ctx, c, &user, req.CurrentPassword)
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
+	if !confirmed {
+		return c.Status(http.StatusForbidden).JSON(responses.UserResponse{Status: http.StatusForbidden, Message: "error", Data: &fiber.Map{"data": errReauthenticate}})
+	}
 
-	if result.DeletedCount < 1 {
+	// the account is locked right away, the rest of the clean up runs as a background job
+	result, err := userCollection.UpdateOne(ctx, bson.M{"id": objId}, bson.M{"$set": bson.M{"deleting": true}})
+
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if result.MatchedCount < 1 {
 		return c.Status(http.StatusNotFound).JSON(
 			responses.UserResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "User with specified ID not found!"}},
 		)
 	}
 
-	return c.Status(http.StatusOK).JSON(
-		responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "User successfully deleted!"}},
+	if err := revokeSessions(ctx, objId, primitive.NilObjectID); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+	if err := revokeAPIKeys(ctx, objId); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	job, err := enqueueJob(ctx, models.JobDeleteUser, objId)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusAccepted).JSON(
+		responses.UserResponse{Status: http.StatusAccepted, Message: "User is being deleted", Data: &fiber.Map{"data": job}},
 	)
 }
 
-func GetAllUsers(c *fiber.Ctx) error {
+// SearchUsers pages through users whose name, email or handle starts with q, the viewer's
+// contacts first and then everyone else, each ordered by id. The viewer is the signed in user,
+// users blocked by or blocking them are left out.
+func SearchUsers(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	var users []models.User
 	defer cancel()
 
-	results, err := userCollection.Find(ctx, bson.M{})
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit < 1 {
+		limit = defaultUsersLimit
+	}
+	if limit > maxUsersLimit {
+		limit = maxUsersLimit
+	}
+
+	filter := bson.M{"deleting": bson.M{"$ne": true}}
 
+	if search := strings.ToLower(strings.TrimSpace(c.Query("q"))); search != "" {
+		prefix := "^" + regexp.QuoteMeta(search)
+		filter["$or"] = []bson.M{
+			{"searchname": bson.M{"$regex": prefix}},
+			{"searchemail": bson.M{"$regex": prefix}},
+			{"handlelower": bson.M{"$regex": "^" + regexp.QuoteMeta(strings.TrimPrefix(search, "@"))}},
+		}
+	}
+
+	// contacts of the viewer come first, so the cursor says which part of the list it is in:
+	// "c_<id>" within contacts, "o_<id>" within everyone else
+	phase, afterId := "c", primitive.NilObjectID
+	if cursor := c.Query("cursor"); cursor != "" {
+		if strings.HasPrefix(cursor, "c_") || strings.HasPrefix(cursor, "o_") {
+			phase, cursor = cursor[:1], cursor[2:]
+		} else {
+			phase = "o"
+		}
+		afterId, err = primitive.ObjectIDFromHex(cursor)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(responses.UserResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Invalid cursor"}})
+		}
+	}
+
+	hidden := []primitive.ObjectID{}
+	contacts := []primitive.ObjectID{}
+	viewerId := authFrom(c).UserId
+	if hidden, err = blockedUserIds(ctx, viewerId); err == nil {
+		contacts, err = contactIds(ctx, viewerId)
+	}
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
 
-	defer results.Close(ctx)
-	for results.Next(ctx) {
-		var singleUser models.User
-		if err = results.Decode(&singleUser); err != nil {
+	findPage := func(idFilter bson.M, count int) ([]models.User, error) {
+		if !afterId.IsZero() {
+			idFilter["$gt"] = afterId
+		}
+		pageFilter := bson.M{"id": idFilter}
+		for key, value := range filter {
+			pageFilter[key] = value
+		}
+
+		opts := options.Find().
+			SetSort(bson.M{"id": 1}).
+			SetLimit(int64(count)).
+			SetProjection(bson.M{"password": 0})
+
+		results, err := userCollection.Find(ctx, pageFilter, opts)
+		if err != nil {
+			return nil, err
+		}
+		page := []models.User{}
+		err = results.All(ctx, &page)
+		return page, err
+	}
+
+	users := []models.User{}
+	contactCount := 0
+	if phase == "c" && len(contacts) > 0 {
+		users, err = findPage(bson.M{"$in": contacts, "$nin": hidden}, limit+1)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+		}
+		contactCount = len(users)
+		afterId = primitive.NilObjectID
+	}
+	if len(users) <= limit {
+		others, err := findPage(bson.M{"$nin": append(hidden, contacts...)}, limit+1-len(users))
+		if err != nil {
 			return c.Status(http.StatusInternalServerError).JSON(responses.UserResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 		}
+		users = append(users, others...)
+	}
+
+	// one extra user was fetched to know if another page exists
+	nextCursor := ""
+	if len(users) > limit {
+		users = users[:limit]
+		nextCursor = "o_" + users[limit-1].Id.Hex()
+		if limit <= contactCount {
+			nextCursor = "c_" + users[limit-1].Id.Hex()
+		}
+	}
 
-		users = append(users, singleUser)
+	public := models.ToPublicUsers(users)
+	viewer := privacyViewerWith(viewerId, contacts)
+	for i := range public {
+		viewer.publicUser(&public[i], users[i].Privacy)
 	}
 
 	return c.Status(http.StatusOK).JSON(
-		responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": users}},
+		responses.UserResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": public, "nextCursor": nextCursor}},
 	)
 }
@@ -0,0 +1,28 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// FileMailer writes every email to its own .eml file in Dir, so tests and local setups can
+// read what would have been sent
+type FileMailer struct {
+	Dir string
+}
+
+func (m FileMailer) Send(to string, subject string, body string) error {
+	if err := os.MkdirAll(m.Dir, 0o750); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(m.Dir, fmt.Sprintf("%d-*.eml", time.Now().UnixMilli()))
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(formatMessage("noreply@localhost", to, subject, body)); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"log"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+)
+
+// Mailer delivers emails to users
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// LogMailer writes emails to the log instead of sending them, for local development
+type LogMailer struct{}
+
+func (LogMailer) Send(to string, subject string, body string) error {
+	log.Printf("Mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// Default is the mailer used by the handlers, picked with the MAILER env variable
+var Default Mailer = fromEnv()
+
+func fromEnv() Mailer {
+	switch configs.GetEnv("MAILER") {
+	case "smtp":
+		return SMTPMailer{
+			Host:     configs.GetEnv("SMTP_HOST"),
+			Port:     configs.GetEnvInt("SMTP_PORT", 587),
+			Username: configs.GetEnv("SMTP_USERNAME"),
+			Password: configs.GetEnv("SMTP_PASSWORD"),
+			From:     configs.GetEnv("MAIL_FROM"),
+		}
+	case "file":
+		dir := configs.GetEnv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return FileMailer{Dir: dir}
+	default:
+		return LogMailer{}
+	}
+}
@@ -1,20 +1,48 @@
 package main
 
 import (
+	"strings"
+
 	"github.com/achintya-7/go-fiber-chat/configs"
+	"github.com/achintya-7/go-fiber-chat/controllers"
 	"github.com/achintya-7/go-fiber-chat/routes"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cache"
+	"github.com/gofiber/fiber/v2/utils"
 )
 
+// paths never answered from the cache
+var uncachedPaths = []string{"/auth/", "/exports/", "/jobs/"}
+
 func main() {
 
 	app := fiber.New()
 
-	// adding cache middleware
-	app.Use(cache.New())
+	// adding cache middleware, keyed on the full url so query params get their own entry
+	// authenticated requests are answered per caller and never cached, nor are the paths
+	// whose answers change from one request to the next
+	app.Use(cache.New(cache.Config{
+		Next: func(c *fiber.Ctx) bool {
+			if c.Get(fiber.HeaderAuthorization) != "" || c.Get("X-API-Key") != "" {
+				return true
+			}
+			for _, prefix := range uncachedPaths {
+				if strings.HasPrefix(c.Path(), prefix) {
+					return true
+				}
+			}
+			return false
+		},
+		KeyGenerator: func(c *fiber.Ctx) string {
+			return utils.CopyString(c.OriginalURL())
+		},
+	}))
 
 	configs.ConnectDB()
+	controllers.RunMigrations()
+	controllers.EnsureIndexes()
+	controllers.StartJobWorker()
+	controllers.StartActivityWatcher()
 
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.Status(200).JSON(fiber.Map{
@@ -23,6 +51,9 @@ func main() {
 		})
 	})
 
+	app.Static("/uploads", controllers.UploadDir)
+
+	routes.AuthRoute(app)
 	routes.UserRoute(app)
 	routes.ChatRoute(app)
 
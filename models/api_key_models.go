@@ -0,0 +1,89 @@
+package models
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKeyScope is an operation an API key may be used for
+type APIKeyScope string
+
+const (
+	ScopeMessagesRead   APIKeyScope = "messages:read"
+	ScopeMessagesSend   APIKeyScope = "messages:send"
+	ScopeReactionsWrite APIKeyScope = "reactions:write"
+)
+
+func (scope APIKeyScope) Valid() bool {
+	return scope == ScopeMessagesRead || scope == ScopeMessagesSend || scope == ScopeReactionsWrite
+}
+
+// APIKeyPrefix starts every API key, telling keys apart from session tokens
+const APIKeyPrefix = "fck_"
+
+// APIKey lets a program act as a user or one of their bots. The key is only stored hashed,
+// Prefix is the start of it kept to tell keys apart in listings.
+type APIKey struct {
+	KeyId      primitive.ObjectID   `json:"keyId"`
+	Prefix     string               `json:"prefix"`
+	KeyHash    string               `json:"-"`
+	UserId     primitive.ObjectID   `json:"userId"`  // who the key acts as
+	OwnerId    primitive.ObjectID   `json:"ownerId"` // who manages the key
+	Name       string               `json:"name"`
+	Scopes     []APIKeyScope        `json:"scopes"`
+	ChatIds    []primitive.ObjectID `json:"chatIds"` // empty for every chat the user is in
+	CreatedAt  int64                `json:"createdAt"`
+	LastUsedAt int64                `json:"lastUsedAt"`
+	RevokedAt  int64                `json:"revokedAt,omitempty"`
+}
+
+// Allows tells if the key has the scope
+func (key *APIKey) Allows(scope APIKeyScope) bool {
+	for _, s := range key.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsChat tells if the key may touch the chat
+func (key *APIKey) AllowsChat(chatId primitive.ObjectID) bool {
+	if len(key.ChatIds) == 0 {
+		return true
+	}
+	for _, id := range key.ChatIds {
+		if id == chatId {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAPIKey returns a random key and the prefix shown for it
+func NewAPIKey() (string, string, error) {
+	token, err := NewToken()
+	if err != nil {
+		return "", "", err
+	}
+	key := APIKeyPrefix + token
+	return key, key[:len(APIKeyPrefix)+8], nil
+}
+
+// IsAPIKey tells API keys apart from session tokens
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+type CreateAPIKeyReq struct {
+	Name    string               `json:"name" validate:"required"`
+	BotId   primitive.ObjectID   `json:"botId"` // leave out for a key acting as the owner
+	Scopes  []APIKeyScope        `json:"scopes" validate:"required"`
+	ChatIds []primitive.ObjectID `json:"chatIds"`
+}
+
+type CreateBotReq struct {
+	Name   string `json:"name" validate:"required"`
+	Handle string `json:"handle"`
+}
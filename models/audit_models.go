@@ -0,0 +1,32 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// AuditEvent is what an audit log entry records
+type AuditEvent string
+
+const (
+	AuditSignInFailed    AuditEvent = "sign_in_failed"
+	AuditAccountLocked   AuditEvent = "account_locked"
+	AuditAccountUnlocked AuditEvent = "account_unlocked"
+)
+
+// AuditLog is a security relevant event. UserId is zero when the email matched no account.
+type AuditLog struct {
+	AuditId   primitive.ObjectID `json:"auditId"`
+	Event     AuditEvent         `json:"event"`
+	UserId    primitive.ObjectID `json:"userId"`
+	Email     string             `json:"email"`
+	IP        string             `json:"ip"`
+	UserAgent string             `json:"userAgent"`
+	CreatedAt int64              `json:"createdAt"`
+}
+
+// SignInCounter counts recent failed sign ins for an email or an IP address, found by key
+type SignInCounter struct {
+	Key           string `json:"key"`
+	Failures      int    `json:"failures"`
+	LastFailureAt int64  `json:"lastFailureAt"`
+	RetryAt       int64  `json:"retryAt"`     // no tries before this, grows with each failure
+	LockedUntil   int64  `json:"lockedUntil"` // set once there were too many failures
+}
@@ -0,0 +1,14 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Block stops the blocked user from reaching the blocker
+type Block struct {
+	BlockerId primitive.ObjectID `json:"blockerId"`
+	BlockedId primitive.ObjectID `json:"blockedId"`
+	CreatedAt int64              `json:"createdAt"`
+}
+
+type BlockReq struct {
+	TargetId primitive.ObjectID `json:"targetId"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type CreateChannelReq struct {
+	UserId   primitive.ObjectID `json:"userId"`
+	ChatName string             `json:"chatName"`
+}
+
+// ChannelRes is a channel chat. Its users are the admins, subscribers are kept as memberships
+// so a channel can have any number of them.
+type ChannelRes struct {
+	ChatId          primitive.ObjectID   `json:"chatId"`
+	Users           []primitive.ObjectID `json:"users"`
+	ChatType        ChatType             `json:"chatType"`
+	LatestMessage   string               `json:"latestMessage"`
+	LatestMessageId string               `json:"latestMessageId"`
+	UserId          primitive.ObjectID   `json:"userId"`
+	ChatName        string               `json:"chatName"`
+	LastActivityAt  int64                `json:"lastActivityAt"`
+	SubscriberCount int64                `json:"subscriberCount"`
+}
+
+type ChannelSubscriptionReq struct {
+	UserId primitive.ObjectID `json:"userId"`
+	ChatId primitive.ObjectID `json:"chatId"`
+}
+
+type AddChannelAdminReq struct {
+	UserId  primitive.ObjectID `json:"userId"`
+	ChatId  primitive.ObjectID `json:"chatId"`
+	AdminId primitive.ObjectID `json:"adminId"`
+}
@@ -1,11 +1,54 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ChatType tells direct chats, groups and broadcast channels apart
+type ChatType string
+
+const (
+	ChatTypeDirect  ChatType = "direct"
+	ChatTypeGroup   ChatType = "group"
+	ChatTypeChannel ChatType = "channel" // only the users of a channel post, subscribers read and react
+)
+
+// GroupVisibility decides if a group can be found and joined by anyone
+type GroupVisibility string
+
+const (
+	GroupPrivate GroupVisibility = "private"
+	GroupPublic  GroupVisibility = "public"
+)
 
 type UserInfo struct {
-	Id   primitive.ObjectID `json:"id"`
-	Name string             `json:"name"`
+	Id              primitive.ObjectID `json:"id"`
+	Name            string             `json:"name"`
+	Handle          string             `json:"handle,omitempty"`
+	AvatarUrl       string             `json:"avatarUrl,omitempty"`
+	StatusText      string             `json:"statusText,omitempty"`
+	StatusExpiresAt int64              `json:"statusExpiresAt,omitempty"`
+	Privacy         PrivacySettings    `json:"-"`
+}
+
+// ApplyPrivacy hides the photo from a viewer the user's settings dont allow
+func (info *UserInfo) ApplyPrivacy(self bool, contact bool) {
+	if !info.Privacy.Photo.Allows(self, contact) {
+		info.AvatarUrl = ""
+	}
+}
+
+// HideExpiredStatus clears a status that has run out
+func (info *UserInfo) HideExpiredStatus() {
+	if StatusExpired(info.StatusExpiresAt) {
+		info.StatusText = ""
+		info.StatusExpiresAt = 0
+	}
 }
+
 type CreateChatReq struct {
 	UserId       primitive.ObjectID `json:"userId"`
 	SecondUserId primitive.ObjectID `json:"secondUserId"`
@@ -14,11 +57,28 @@ type CreateChatReq struct {
 type CreateChatRes struct {
 	ChatId          primitive.ObjectID   `json:"chatId"`
 	Users           []primitive.ObjectID `json:"users"`
-	IsGroup         bool                 `json:"isGroup"`
+	ChatType        ChatType             `json:"chatType"`
 	LatestMessage   string               `json:"latestMessage"`
 	LatestMessageId string               `json:"latestMessageId"`
 	UserId          primitive.ObjectID   `json:"userId"`
 	ChatName        string               `json:"chatName"`
+	LastActivityAt  int64                `json:"lastActivityAt"` // unix millis of the latest message
+	PairKey         string               `json:"pairKey,omitempty"`
+}
+
+// DirectPairKey identifies the direct chat between a set of users regardless of their order
+func DirectPairKey(userIds ...primitive.ObjectID) string {
+	seen := make(map[string]bool, len(userIds))
+	keys := make([]string, 0, len(userIds))
+	for _, id := range userIds {
+		hex := id.Hex()
+		if !seen[hex] {
+			seen[hex] = true
+			keys = append(keys, hex)
+		}
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ":")
 }
 
 type GetAllChatsReq struct {
@@ -26,13 +86,19 @@ type GetAllChatsReq struct {
 }
 
 type CreateChatRes2 struct {
-	ChatId          primitive.ObjectID `json:"chatId"`
-	Users           []UserInfo         `json:"users"`
-	IsGroup         bool               `json:"isGroup"`
-	LatestMessage   string             `json:"latestMessage"`
-	LatestMessageId string             `json:"latestMessageId"`
-	UserId          primitive.ObjectID `json:"userId"`
-	ChatName        string             `json:"chatName"`
+	ChatId          primitive.ObjectID   `json:"chatId"`
+	Users           []UserInfo           `json:"users"`
+	ChatType        ChatType             `json:"chatType"`
+	LatestMessage   string               `json:"latestMessage"`
+	LatestMessageId string               `json:"latestMessageId"`
+	UserId          primitive.ObjectID   `json:"userId"`
+	ChatName        string               `json:"chatName"`
+	LastActivityAt  int64                `json:"lastActivityAt"`
+	SubscriberCount int64                `json:"subscriberCount"`
+	DeletedUsers    []primitive.ObjectID `json:"deletedUsers,omitempty"`
+	Description     string               `json:"description"`
+	Visibility      GroupVisibility      `json:"visibility"`
+	State           *ChatMembership      `json:"state"`
 }
 
 type GetAllChatsRes struct {
@@ -41,6 +107,7 @@ type GetAllChatsRes struct {
 }
 
 type AddToGroupReq struct {
+	UserId primitive.ObjectID   `json:"userId"` // the member adding the users
 	Users  []primitive.ObjectID `json:"users"`
 	ChatId primitive.ObjectID   `json:"chatId"`
 }
@@ -51,17 +118,43 @@ type DeleteFromGroupReq struct {
 }
 
 type CreateGroupChatReq struct {
-	UserId   primitive.ObjectID   `json:"userId"`
-	Users    []primitive.ObjectID `json:"users"`
-	ChatName string               `json:"chatName"`
+	UserId      primitive.ObjectID   `json:"userId"`
+	Users       []primitive.ObjectID `json:"users"`
+	ChatName    string               `json:"chatName"`
+	Description string               `json:"description"`
+	Visibility  GroupVisibility      `json:"visibility"`
 }
 
 type CreateGroupChatRes struct {
 	ChatId          primitive.ObjectID   `json:"chatId"`
 	Users           []primitive.ObjectID `json:"users"`
-	IsGroup         bool                 `json:"isGroup"`
+	ChatType        ChatType             `json:"chatType"`
 	LatestMessage   string               `json:"latestMessage"`
 	LatestMessageId string               `json:"latestMessageId"`
 	UserId          primitive.ObjectID   `json:"userId"`
 	ChatName        string               `json:"chatName"`
+	LastActivityAt  int64                `json:"lastActivityAt"`
+	Description     string               `json:"description"`
+	Visibility      GroupVisibility      `json:"visibility"`
+}
+
+type UpdateGroupReq struct {
+	UserId      primitive.ObjectID `json:"userId"`
+	Description *string            `json:"description"`
+	Visibility  *GroupVisibility   `json:"visibility"`
+}
+
+// DiscoverGroupRes is a public group as listed to people who are not in it yet
+type DiscoverGroupRes struct {
+	ChatId         primitive.ObjectID `json:"chatId"`
+	ChatName       string             `json:"chatName"`
+	Description    string             `json:"description"`
+	MemberCount    int                `json:"memberCount"`
+	LastActivityAt int64              `json:"lastActivityAt"`
+}
+
+// InvalidUser reports a user id that was rejected from a chat request
+type InvalidUser struct {
+	UserId primitive.ObjectID `json:"userId"`
+	Reason string             `json:"reason"`
 }
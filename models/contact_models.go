@@ -0,0 +1,33 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type ContactStatus string
+
+const (
+	ContactPending  ContactStatus = "pending"
+	ContactAccepted ContactStatus = "accepted"
+)
+
+// ContactRequest is a friend request, it stays as the contact once accepted.
+// Declined requests are deleted so they can be sent again later.
+type ContactRequest struct {
+	RequestId   primitive.ObjectID `json:"requestId"`
+	FromId      primitive.ObjectID `json:"fromId"`
+	ToId        primitive.ObjectID `json:"toId"`
+	PairKey     string             `json:"-"`
+	Status      ContactStatus      `json:"status"`
+	CreatedAt   int64              `json:"createdAt"`
+	RespondedAt int64              `json:"respondedAt"`
+}
+
+type ContactReq struct {
+	TargetId primitive.ObjectID `json:"targetId"`
+}
+
+// ContactRes is a contact with their presence
+type ContactRes struct {
+	UserInfo
+	Online     bool  `json:"online"`
+	LastSeenAt int64 `json:"lastSeenAt"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Identity links an account at an OpenID Connect provider to a user. The subject is the
+// provider's id for the account, which unlike the email never changes.
+type Identity struct {
+	Provider  string             `json:"provider"`
+	Subject   string             `json:"subject"`
+	UserId    primitive.ObjectID `json:"userId"`
+	Email     string             `json:"email"`
+	CreatedAt int64              `json:"createdAt"`
+}
+
+// OIDCState is a sign in with a provider that was started and not finished yet, found by the
+// hash of the state sent along to the provider
+type OIDCState struct {
+	StateHash string `json:"-"`
+	Provider  string `json:"provider"`
+	Verifier  string `json:"-"`
+	Nonce     string `json:"-"`
+	CreatedAt int64  `json:"createdAt"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type JobType string
+
+const (
+	JobDeleteUser JobType = "delete_user"
+	JobExportUser JobType = "export_user"
+)
+
+type JobStatus string
+
+const (
+	JobPending JobStatus = "pending"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// Job is background work that survives restarts. Step is the next step to run, so a job
+// picked up again after a crash carries on where it stopped.
+type Job struct {
+	JobId       primitive.ObjectID `json:"jobId"`
+	Type        JobType            `json:"type"`
+	UserId      primitive.ObjectID `json:"userId"`
+	Status      JobStatus          `json:"status"`
+	Step        int                `json:"step"`
+	Attempts    int                `json:"attempts"`
+	Error       string             `json:"error,omitempty"`
+	TokenHash   string             `json:"-"`                   // download token of a finished export
+	ExpiresAt   int64              `json:"expiresAt,omitempty"` // when the export stops being downloadable
+	LockedUntil int64              `json:"-"`
+	CreatedAt   int64              `json:"createdAt"`
+	UpdatedAt   int64              `json:"updatedAt"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// ChatMembership holds one user's personal settings for a chat
+type ChatMembership struct {
+	ChatId       primitive.ObjectID `json:"chatId"`
+	UserId       primitive.ObjectID `json:"userId"`
+	Archived     bool               `json:"archived"`
+	Muted        bool               `json:"muted"`
+	MutedUntil   int64              `json:"mutedUntil"` // unix millis, 0 mutes until unmuted
+	Pinned       bool               `json:"pinned"`
+	PinOrder     int                `json:"pinOrder"`
+	MarkedUnread bool               `json:"markedUnread"`
+	Subscribed   bool               `json:"subscribed"` // reads a channel without being one of its users
+}
+
+// UpdateChatStateReq only changes the fields that are sent
+type UpdateChatStateReq struct {
+	Archived     *bool  `json:"archived"`
+	Muted        *bool  `json:"muted"`
+	MutedUntil   *int64 `json:"mutedUntil"`
+	Pinned       *bool  `json:"pinned"`
+	PinOrder     *int   `json:"pinOrder"`
+	MarkedUnread *bool  `json:"markedUnread"`
+}
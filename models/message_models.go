@@ -10,3 +10,36 @@ type Message struct {
 	MessageId   string             `json:"messageId"`
 	Timestamp   int64              `json:"timestamp"`
 }
+
+type SendMessageReq struct {
+	UserId      primitive.ObjectID `json:"userId"`
+	RoomId      primitive.ObjectID `json:"roomId"`
+	Content     string             `json:"content"`
+	ContentType string             `json:"contentType"`
+}
+
+type Reaction struct {
+	MessageId string             `json:"messageId"`
+	RoomId    primitive.ObjectID `json:"roomId"`
+	UserId    primitive.ObjectID `json:"userId"`
+	Emoji     string             `json:"emoji"`
+	Timestamp int64              `json:"timestamp"`
+}
+
+type ReactionReq struct {
+	UserId    primitive.ObjectID `json:"userId"`
+	MessageId string             `json:"messageId"`
+	Emoji     string             `json:"emoji"`
+}
+
+// MessageRes is a message as listed to clients, with its reactions
+type MessageRes struct {
+	UserId      primitive.ObjectID `json:"userId"`
+	RoomId      primitive.ObjectID `json:"roomId"`
+	Content     string             `json:"content"`
+	ContentType string             `json:"contentType"`
+	MessageId   string             `json:"messageId"`
+	Timestamp   int64              `json:"timestamp"`
+	Author      *UserInfo          `json:"author"`
+	Reactions   []Reaction         `json:"reactions"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Passkey is a WebAuthn credential a user signs in with. Binary values are unpadded base64url,
+// as browsers send them.
+type Passkey struct {
+	CredentialId string             `json:"credentialId"`
+	UserId       primitive.ObjectID `json:"userId"`
+	Name         string             `json:"name"`
+	PublicKey    []byte             `json:"-"` // COSE encoded
+	SignCount    int64              `json:"-"`
+	AAGUID       string             `json:"aaguid"`
+	Transports   []string           `json:"transports"`
+	CreatedAt    int64              `json:"createdAt"`
+	LastUsedAt   int64              `json:"lastUsedAt"`
+}
+
+// PasskeyRegisterReq is the credential navigator.credentials.create made
+type PasskeyRegisterReq struct {
+	Name              string   `json:"name"`
+	ClientDataJSON    string   `json:"clientDataJSON" validate:"required"`
+	AttestationObject string   `json:"attestationObject" validate:"required"`
+	Transports        []string `json:"transports"`
+}
+
+// PasskeySignInReq is the assertion navigator.credentials.get made
+type PasskeySignInReq struct {
+	CredentialId      string `json:"credentialId" validate:"required"`
+	ClientDataJSON    string `json:"clientDataJSON" validate:"required"`
+	AuthenticatorData string `json:"authenticatorData" validate:"required"`
+	Signature         string `json:"signature" validate:"required"`
+	UserHandle        string `json:"userHandle"`
+}
@@ -0,0 +1,58 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// PrivacyLevel is who may see or do something a privacy setting guards
+type PrivacyLevel string
+
+const (
+	PrivacyEveryone PrivacyLevel = "everyone"
+	PrivacyContacts PrivacyLevel = "contacts"
+	PrivacyNobody   PrivacyLevel = "nobody"
+)
+
+func (level PrivacyLevel) Valid() bool {
+	return level == PrivacyEveryone || level == PrivacyContacts || level == PrivacyNobody
+}
+
+// Allows tells if a viewer passes the level. Users always pass their own settings, and an
+// unset level lets everyone through.
+func (level PrivacyLevel) Allows(self bool, contact bool) bool {
+	switch {
+	case self:
+		return true
+	case level == PrivacyNobody:
+		return false
+	case level == PrivacyContacts:
+		return contact
+	default:
+		return true
+	}
+}
+
+// PrivacySettings decide who sees a user's presence, photo and bio, and who may add them
+// to groups without asking. The zero value lets everyone.
+type PrivacySettings struct {
+	LastSeen  PrivacyLevel `json:"lastSeen,omitempty"`
+	Photo     PrivacyLevel `json:"photo,omitempty"`
+	Bio       PrivacyLevel `json:"bio,omitempty"`
+	GroupAdds PrivacyLevel `json:"groupAdds,omitempty"`
+}
+
+// UpdatePrivacyReq only changes the settings that are sent
+type UpdatePrivacyReq struct {
+	LastSeen  *PrivacyLevel `json:"lastSeen"`
+	Photo     *PrivacyLevel `json:"photo"`
+	Bio       *PrivacyLevel `json:"bio"`
+	GroupAdds *PrivacyLevel `json:"groupAdds"`
+}
+
+// GroupInvite is sent instead of adding a user whose settings dont let the inviter add them
+type GroupInvite struct {
+	InviteId  primitive.ObjectID `json:"inviteId"`
+	ChatId    primitive.ObjectID `json:"chatId"`
+	ChatName  string             `json:"chatName"`
+	FromId    primitive.ObjectID `json:"fromId"`
+	ToId      primitive.ObjectID `json:"toId"`
+	CreatedAt int64              `json:"createdAt"`
+}
@@ -0,0 +1,75 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// PublicUser is how a user is shown to other users. It has no password field, so a hash
+// can't leak through a response by accident, and leaves out the email and account settings.
+type PublicUser struct {
+	Id              primitive.ObjectID `json:"id"`
+	Name            string             `json:"name"`
+	Bot             bool               `json:"bot"`
+	Handle          string             `json:"handle"`
+	AvatarUrl       string             `json:"avatarUrl"`
+	Bio             string             `json:"bio"`
+	StatusText      string             `json:"statusText"`
+	StatusExpiresAt int64              `json:"statusExpiresAt"`
+}
+
+// SelfUser is how a user is shown to themselves, with their email and settings
+type SelfUser struct {
+	PublicUser
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"emailVerified"`
+	TwoFactor     bool   `json:"twoFactor"`
+
+	DmsFromContactsOnly bool            `json:"dmsFromContactsOnly"`
+	Privacy             PrivacySettings `json:"privacy"`
+}
+
+func ToPublicUser(user User) PublicUser {
+	public := PublicUser{
+		Id:              user.Id,
+		Name:            user.Name,
+		Bot:             user.IsBot,
+		Handle:          user.Handle,
+		AvatarUrl:       user.AvatarUrl,
+		Bio:             user.Bio,
+		StatusText:      user.StatusText,
+		StatusExpiresAt: user.StatusExpiresAt,
+	}
+	if StatusExpired(public.StatusExpiresAt) {
+		public.StatusText = ""
+		public.StatusExpiresAt = 0
+	}
+	return public
+}
+
+func ToSelfUser(user User) SelfUser {
+	return SelfUser{
+		PublicUser:    ToPublicUser(user),
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		TwoFactor:     user.TwoFactor.Enabled,
+
+		DmsFromContactsOnly: user.DmsFromContactsOnly,
+		Privacy:             user.Privacy,
+	}
+}
+
+// ApplyPrivacy hides the photo and bio from a viewer the user's settings dont allow
+func (public *PublicUser) ApplyPrivacy(privacy PrivacySettings, self bool, contact bool) {
+	if !privacy.Photo.Allows(self, contact) {
+		public.AvatarUrl = ""
+	}
+	if !privacy.Bio.Allows(self, contact) {
+		public.Bio = ""
+	}
+}
+
+func ToPublicUsers(users []User) []PublicUser {
+	public := make([]PublicUser, 0, len(users))
+	for _, user := range users {
+		public = append(public, ToPublicUser(user))
+	}
+	return public
+}
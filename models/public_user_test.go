@@ -0,0 +1,87 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testPasswordHash = "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"
+
+func testUser() User {
+	return User{
+		Id:           primitive.NewObjectID(),
+		Name:         "Ada",
+		Email:        "ada@example.com",
+		Password:     testPasswordHash,
+		Handle:       "ada",
+		AvatarUrl:    "/uploads/ada.png",
+		Bio:          "hello",
+		StatusText:   "around",
+		PendingEmail: "ada@example.org",
+		TwoFactor: TwoFactor{
+			Enabled:       true,
+			Secret:        "JBSWY3DPEHPK3PXP",
+			PendingSecret: "KRSXG5CTMVRXEZLU",
+			RecoveryCodes: []string{"b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"},
+		},
+	}
+}
+
+// every shape a user is sent to clients in
+func userResponses(user User) map[string]interface{} {
+	info := UserInfo{Id: user.Id, Name: user.Name, Handle: user.Handle, AvatarUrl: user.AvatarUrl, Privacy: user.Privacy}
+	return map[string]interface{}{
+		"PublicUser":     ToPublicUser(user),
+		"PublicUsers":    ToPublicUsers([]User{user}),
+		"SelfUser":       ToSelfUser(user),
+		"UserInfo":       info,
+		"ContactRes":     ContactRes{UserInfo: info, Online: true},
+		"MessageRes":     MessageRes{Author: &info},
+		"CreateChatRes2": CreateChatRes2{Users: []UserInfo{info}},
+	}
+}
+
+func TestUserResponsesHaveNoSecrets(t *testing.T) {
+	user := testUser()
+	secrets := append([]string{user.Password, user.TwoFactor.Secret, user.TwoFactor.PendingSecret}, user.TwoFactor.RecoveryCodes...)
+
+	for name, response := range userResponses(user) {
+		encoded, err := json.Marshal(response)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		text := string(encoded)
+		if strings.Contains(strings.ToLower(text), "password") {
+			t.Errorf("%s has a password field: %s", name, text)
+		}
+		for _, secret := range secrets {
+			if strings.Contains(text, secret) {
+				t.Errorf("%s contains the secret %q: %s", name, secret, text)
+			}
+		}
+	}
+}
+
+func TestPublicUserHidesAccountDetails(t *testing.T) {
+	user := testUser()
+	encoded, err := json.Marshal(ToPublicUsers([]User{user}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, private := range []string{user.Email, user.PendingEmail, `"twoFactor"`, `"emailVerified"`, `"privacy"`, `"dmsFromContactsOnly"`} {
+		if strings.Contains(string(encoded), private) {
+			t.Errorf("public user shows %s: %s", private, encoded)
+		}
+	}
+
+	self, err := json.Marshal(ToSelfUser(user))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(self), `"email":"ada@example.com"`) || !strings.Contains(string(self), `"twoFactor":true`) {
+		t.Errorf("self user is missing the account details: %s", self)
+	}
+}
@@ -0,0 +1,12 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Session is a signed in device, found by the hash of its bearer token
+type Session struct {
+	SessionId primitive.ObjectID `json:"sessionId"`
+	UserId    primitive.ObjectID `json:"userId"`
+	TokenHash string             `json:"-"`
+	CreatedAt int64              `json:"createdAt"`
+	ExpiresAt int64              `json:"expiresAt"`
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TokenPurpose says what a one time token can be used for
+type TokenPurpose string
+
+const (
+	TokenEmailChange   TokenPurpose = "email_change"
+	TokenPasswordReset TokenPurpose = "password_reset"
+	TokenEmailVerify   TokenPurpose = "email_verify"
+	TokenSignIn2FA     TokenPurpose = "sign_in_2fa"
+	TokenAccountUnlock TokenPurpose = "account_unlock"
+	TokenPasskeyCreate TokenPurpose = "passkey_create"
+	TokenPasskeyGet    TokenPurpose = "passkey_get"
+	TokenMagicLink     TokenPurpose = "magic_link"
+)
+
+// OneTimeToken is stored by hash only, the token itself is only ever sent to the user
+type OneTimeToken struct {
+	TokenHash string             `json:"-"`
+	UserId    primitive.ObjectID `json:"userId"`
+	Purpose   TokenPurpose       `json:"purpose"`
+	Email     string             `json:"email"`
+	CreatedAt int64              `json:"createdAt"`
+	ExpiresAt int64              `json:"expiresAt"`
+	Used      bool               `json:"used"`
+	Attempts  int                `json:"-"` // failed tries, for tokens that are checked along with a code
+	// hash of a secret kept on the device that asked for the token, for tokens only that
+	// device may use
+	DeviceHash string `json:"-"`
+}
+
+// NewToken returns a random url safe token
+func NewToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashToken is how tokens are looked up without storing them
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
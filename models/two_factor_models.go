@@ -0,0 +1,70 @@
+package models
+
+import (
+	"crypto/rand"
+	"strings"
+)
+
+// TwoFactor holds a user's authenticator app setup. Secret is only set once enrollment was
+// confirmed with a first code, until then the new secret waits in PendingSecret.
+type TwoFactor struct {
+	Enabled       bool   `json:"-"`
+	Secret        string `json:"-"`
+	PendingSecret string `json:"-"`
+	// the time step of the last accepted code, so a code cant be used twice
+	LastCounter int64 `json:"-"`
+	// hashes of the unused recovery codes
+	RecoveryCodes []string `json:"-"`
+}
+
+// TwoFactorEnrollRes is shown once, for the user to add the secret to their authenticator app
+type TwoFactorEnrollRes struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TwoFactorCodeReq struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// TwoFactorEnrollReq proves it is the user changing their sign in, see TwoFactorDisableReq
+type TwoFactorEnrollReq struct {
+	CurrentPassword string `json:"currentPassword"`
+}
+
+// TwoFactorDisableReq proves the user still has their app, or a recovery code. The current
+// password is needed too, unless the session signed in moments ago.
+type TwoFactorDisableReq struct {
+	Code            string `json:"code"`
+	RecoveryCode    string `json:"recoveryCode"`
+	CurrentPassword string `json:"currentPassword"`
+}
+
+// TwoFactorSignInReq finishes a sign in with either a code from the app or a recovery code
+type TwoFactorSignInReq struct {
+	Challenge    string `json:"challenge" validate:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// NewRecoveryCodes returns n random single use codes in the form xxxxx-xxxxx
+func NewRecoveryCodes(n int) ([]string, error) {
+	const alphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		buf := make([]byte, 10)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		for j := range buf {
+			buf[j] = alphabet[int(buf[j])%len(alphabet)]
+		}
+		codes = append(codes, string(buf[:5])+"-"+string(buf[5:]))
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode lets a code be typed in any case, with or without the dash
+func NormalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -1,8 +1,12 @@
 package models
 
 import (
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // User model
@@ -11,6 +15,76 @@ type User struct {
 	Name     string             `json:"name"`
 	Email    string             `json:"email" validate:"required"`
 	Password string             `json:"password" validate:"required"`
+
+	// profile
+	Handle          string `json:"-"`
+	AvatarUrl       string `json:"-"`
+	Bio             string `json:"-"`
+	StatusText      string `json:"-"`
+	StatusExpiresAt int64  `json:"-"` // unix millis, 0 keeps the status until it is changed
+
+	// settings
+	DmsFromContactsOnly bool            `json:"-"`
+	Privacy             PrivacySettings `json:"-"`
+
+	// set once the user confirmed they own the email
+	EmailVerified bool `json:"-"`
+
+	// bots are accounts run by a program through API keys, managed by their owner
+	IsBot   bool               `json:"-"`
+	OwnerId primitive.ObjectID `json:"-"`
+
+	// two factor sign in, see TwoFactor
+	TwoFactor TwoFactor `json:"-"`
+
+	// set once the account is being deleted
+	Deleting bool `json:"-"`
+
+	// presence, unix millis of the last sign in, message or heartbeat
+	LastSeenAt int64 `json:"-"`
+
+	// lower cased copy of the handle, unique across users
+	HandleLower string `json:"-"`
+
+	// lower cased copies of name and email for indexed prefix search
+	SearchName  string `json:"-"`
+	SearchEmail string `json:"-"`
+
+	// an email change waiting for the new address to be confirmed
+	PendingEmail string `json:"-"`
+}
+
+// SetSearchKeys refreshes the search copies after name, email or handle changed
+func (user *User) SetSearchKeys() {
+	user.SearchName = strings.ToLower(strings.TrimSpace(user.Name))
+	user.SearchEmail = NormalizeEmail(user.Email)
+	user.HandleLower = strings.ToLower(user.Handle)
+}
+
+// handles are 3 to 30 letters, digits or underscores, shown with a leading @
+var handlePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+
+// NormalizeHandle strips the leading @ and reports if what is left is a valid handle
+func NormalizeHandle(handle string) (string, bool) {
+	handle = strings.TrimPrefix(strings.TrimSpace(handle), "@")
+	return handle, handlePattern.MatchString(handle)
+}
+
+// NormalizeEmail is how emails are stored and looked up, so one address cant be registered
+// twice with different case or surrounding spaces
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// ValidEmail accepts a bare RFC 5322 address, without a display name or angle brackets
+func ValidEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
+
+// StatusExpired tells if a status with the given expiry has run out
+func StatusExpired(expiresAt int64) bool {
+	return expiresAt != 0 && expiresAt <= time.Now().UnixMilli()
 }
 
 // func (user *User) SetPassword(password string) {
@@ -21,11 +95,45 @@ type User struct {
 // 	return bcrypt.CompareHashAndPassword(user.Password, []byte(password))
 // }
 
-func SetPassword2(password string) string {
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(hashedPassword)
+// UpdateUserReq only changes the fields that are sent
+type UpdateUserReq struct {
+	Name            *string `json:"name"`
+	Email           *string `json:"email"`
+	Handle          *string `json:"handle"`
+	Bio             *string `json:"bio"`
+	StatusText      *string `json:"statusText"`
+	StatusExpiresAt *int64  `json:"statusExpiresAt"`
+
+	DmsFromContactsOnly *bool             `json:"dmsFromContactsOnly"`
+	Privacy             *UpdatePrivacyReq `json:"privacy"`
+	// needed to change the email, unless the session signed in moments ago
+	CurrentPassword string `json:"currentPassword"`
+}
+
+// ChangePasswordReq needs the current password, unless the session signed in moments ago
+type ChangePasswordReq struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword" validate:"required"`
+}
+
+// DeleteUserReq needs the current password, unless the session signed in moments ago
+type DeleteUserReq struct {
+	CurrentPassword string `json:"currentPassword"`
+}
+
+type ForgotPasswordReq struct {
+	Email string `json:"email" validate:"required"`
+}
+
+type MagicLinkReq struct {
+	Email string `json:"email" validate:"required"`
+}
+
+type ResetPasswordReq struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"newPassword" validate:"required"`
 }
 
-func ComparePassword2(password string, passwordHash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password))
+type VerifyTokenReq struct {
+	Token string `json:"token" validate:"required"`
 }
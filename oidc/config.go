@@ -0,0 +1,38 @@
+package oidc
+
+import (
+	"log"
+	"strings"
+)
+
+// FromEnv reads the configured providers by name, with getenv looking up env variables.
+// OIDC_PROVIDERS lists the names, comma separated, and each name is configured with
+// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID and OIDC_<NAME>_CLIENT_SECRET. The provider
+// redirects back to OIDC_REDIRECT_BASE/auth/oidc/<name>/callback.
+func FromEnv(getenv func(string) string) map[string]*Provider {
+	providers := map[string]*Provider{}
+
+	base := strings.TrimSuffix(getenv("OIDC_REDIRECT_BASE"), "/")
+	for _, name := range strings.Split(getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider := &Provider{
+			Name:         name,
+			Issuer:       getenv(prefix + "ISSUER"),
+			ClientId:     getenv(prefix + "CLIENT_ID"),
+			ClientSecret: getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  base + "/auth/oidc/" + name + "/callback",
+			Scopes:       []string{"openid", "email", "profile"},
+		}
+		if provider.Issuer == "" || provider.ClientId == "" || base == "" {
+			log.Printf("Skipping OIDC provider %s, it needs an issuer, a client id and OIDC_REDIRECT_BASE", name)
+			continue
+		}
+		providers[name] = provider
+	}
+	return providers
+}
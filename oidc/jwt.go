@@ -0,0 +1,220 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// leeway allows for clock drift between the server and the provider
+const leeway = time.Minute
+
+// keys are fetched again for an unknown key id at most this often, so tokens with made up ids
+// cant make every request hit the provider
+const keyRefreshInterval = 5 * time.Minute
+
+// keySet is the issuer's published signing keys by key id
+type keySet struct {
+	uri string
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// key returns the key with the id, fetching the set again when it isnt known
+func (s *keySet) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(s.fetchedAt) < keyRefreshInterval {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := doJSON(req, &set); err != nil {
+		return nil, err
+	}
+	s.fetchedAt = time.Now()
+
+	s.keys = map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") || (k.Alg != "" && k.Alg != "RS256") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			continue
+		}
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+		if exponent < 3 {
+			continue
+		}
+		s.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}
+	}
+
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+}
+
+// audience is a string or a list of strings in a token
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// flexBool is a boolean some providers send as the string "true"
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	var value bool
+	if err := json.Unmarshal(data, &value); err == nil {
+		*b = flexBool(value)
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	*b = flexBool(text == "true")
+	return nil
+}
+
+// verify checks the ID token's signature, issuer, audience and lifetime
+func (p *Provider) verify(ctx context.Context, d *discovery, token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("oidc: ID token is malformed")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("oidc: ID token algorithm %q is not supported", header.Alg)
+	}
+
+	p.mu.Lock()
+	keys := p.keys
+	p.mu.Unlock()
+	key, err := keys.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("oidc: ID token signature is malformed")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, errors.New("oidc: ID token signature is invalid")
+	}
+
+	var payload struct {
+		Issuer            string   `json:"iss"`
+		Subject           string   `json:"sub"`
+		Audience          audience `json:"aud"`
+		AuthorizedParty   string   `json:"azp"`
+		Expiry            int64    `json:"exp"`
+		IssuedAt          int64    `json:"iat"`
+		Nonce             string   `json:"nonce"`
+		Email             string   `json:"email"`
+		EmailVerified     flexBool `json:"email_verified"`
+		Name              string   `json:"name"`
+		PreferredUsername string   `json:"preferred_username"`
+	}
+	if err := decodeSegment(parts[1], &payload); err != nil {
+		return nil, err
+	}
+
+	if payload.Issuer != d.Issuer {
+		return nil, errors.New("oidc: ID token is from another issuer")
+	}
+	if payload.Subject == "" {
+		return nil, errors.New("oidc: ID token has no subject")
+	}
+	found := false
+	for _, aud := range payload.Audience {
+		found = found || aud == p.ClientId
+	}
+	if !found || (len(payload.Audience) > 1 && payload.AuthorizedParty != p.ClientId) {
+		return nil, errors.New("oidc: ID token is for another client")
+	}
+	if now.After(time.Unix(payload.Expiry, 0).Add(leeway)) {
+		return nil, errors.New("oidc: ID token has expired")
+	}
+	if payload.IssuedAt != 0 && time.Unix(payload.IssuedAt, 0).After(now.Add(leeway)) {
+		return nil, errors.New("oidc: ID token is issued in the future")
+	}
+
+	name := payload.Name
+	if name == "" {
+		name = payload.PreferredUsername
+	}
+	return &Claims{
+		Issuer:        payload.Issuer,
+		Subject:       payload.Subject,
+		Email:         payload.Email,
+		EmailVerified: bool(payload.EmailVerified),
+		Name:          name,
+		Nonce:         payload.Nonce,
+	}, nil
+}
+
+func decodeSegment(segment string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.New("oidc: ID token is malformed")
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return errors.New("oidc: ID token is malformed")
+	}
+	return nil
+}
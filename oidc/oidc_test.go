@@ -0,0 +1,273 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testClientId = "chat-client"
+const testKeyId = "test-key"
+
+// mockIssuer is a local OIDC provider. Its token endpoint answers with whatever ID token the
+// test set, after checking the code and PKCE verifier it was sent.
+type mockIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu       sync.Mutex
+	code     string
+	verifier string
+	idToken  string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &mockIssuer{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": testKeyId,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		issuer.mu.Lock()
+		defer issuer.mu.Unlock()
+
+		clientId, _, _ := r.BasicAuth()
+		if r.PostFormValue("grant_type") != "authorization_code" || clientId != testClientId ||
+			r.PostFormValue("code") != issuer.code || Challenge(r.PostFormValue("code_verifier")) != Challenge(issuer.verifier) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": issuer.idToken, "token_type": "Bearer"})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (m *mockIssuer) provider() *Provider {
+	return &Provider{
+		Name:         "mock",
+		Issuer:       m.server.URL,
+		ClientId:     testClientId,
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/auth/oidc/mock/callback",
+		Scopes:       []string{"openid", "email"},
+	}
+}
+
+// sign makes an ID token with the claims, signed by the key
+func sign(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": testKeyId, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (m *mockIssuer) claims(nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":            m.server.URL,
+		"sub":            "user-1",
+		"aud":            testClientId,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          "ada@example.com",
+		"email_verified": true,
+		"name":           "Ada",
+	}
+}
+
+// exchange runs the code exchange with the ID token the issuer hands out
+func (m *mockIssuer) exchange(t *testing.T, idToken string, nonce string) (*Claims, error) {
+	verifier, err := NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.mu.Lock()
+	m.code, m.verifier, m.idToken = "code-1", verifier, idToken
+	m.mu.Unlock()
+
+	return m.provider().Exchange(context.Background(), "code-1", verifier, nonce)
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	issuer := newMockIssuer(t)
+
+	redirect, err := issuer.provider().AuthCodeURL(context.Background(), "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(redirect, issuer.server.URL+"/authorize?") {
+		t.Fatalf("redirect goes to %s", redirect)
+	}
+	for _, param := range []string{"state=state-1", "nonce=nonce-1", "code_challenge=" + Challenge("verifier-1"), "code_challenge_method=S256"} {
+		if !strings.Contains(redirect, param) {
+			t.Errorf("redirect %s is missing %s", redirect, param)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	issuer := newMockIssuer(t)
+
+	claims, err := issuer.exchange(t, sign(t, issuer.key, issuer.claims("nonce-1")), "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "user-1" || claims.Email != "ada@example.com" || !claims.EmailVerified || claims.Name != "Ada" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.code, issuer.verifier, issuer.idToken = "code-1", "the-right-verifier", sign(t, issuer.key, issuer.claims("nonce-1"))
+
+	if _, err := issuer.provider().Exchange(context.Background(), "code-1", "another-verifier", "nonce-1"); err == nil {
+		t.Fatal("exchange passed with the wrong PKCE verifier")
+	}
+}
+
+func TestExchangeRejectsTokens(t *testing.T) {
+	issuer := newMockIssuer(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		key   *rsa.PrivateKey
+		edit  func(claims map[string]interface{})
+		nonce string
+		want  string
+	}{
+		{name: "bad signature", key: otherKey, want: "signature is invalid"},
+		{name: "wrong audience", want: "another client", edit: func(claims map[string]interface{}) { claims["aud"] = "another-client" }},
+		{name: "wrong audience in a list", want: "another client", edit: func(claims map[string]interface{}) {
+			claims["aud"] = []string{"another-client", testClientId}
+			claims["azp"] = "another-client"
+		}},
+		{name: "wrong issuer", want: "another issuer", edit: func(claims map[string]interface{}) { claims["iss"] = "https://evil.example.com" }},
+		{name: "expired", want: "expired", edit: func(claims map[string]interface{}) {
+			claims["exp"] = time.Now().Add(-time.Hour).Unix()
+			claims["iat"] = time.Now().Add(-2 * time.Hour).Unix()
+		}},
+		{name: "issued in the future", want: "future", edit: func(claims map[string]interface{}) {
+			claims["iat"] = time.Now().Add(time.Hour).Unix()
+		}},
+		{name: "no subject", want: "no subject", edit: func(claims map[string]interface{}) { delete(claims, "sub") }},
+		{name: "nonce mismatch", nonce: "another-nonce", want: "nonce"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := issuer.claims("nonce-1")
+			if test.edit != nil {
+				test.edit(claims)
+			}
+			key := issuer.key
+			if test.key != nil {
+				key = test.key
+			}
+			nonce := "nonce-1"
+			if test.nonce != "" {
+				nonce = test.nonce
+			}
+
+			_, err := issuer.exchange(t, sign(t, key, claims), nonce)
+			if err == nil {
+				t.Fatal("token was accepted")
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Errorf("token was rejected for %q, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestExchangeRejectsTamperedToken(t *testing.T) {
+	issuer := newMockIssuer(t)
+	token := sign(t, issuer.key, issuer.claims("nonce-1"))
+
+	// swap the payload for one claiming another user, keeping the original signature
+	claims := issuer.claims("nonce-1")
+	claims["sub"] = "user-2"
+	payload, _ := json.Marshal(claims)
+	parts := strings.Split(token, ".")
+	parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+
+	if _, err := issuer.exchange(t, strings.Join(parts, "."), "nonce-1"); err == nil {
+		t.Fatal("tampered token was accepted")
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := issuer.provider()
+	provider.Issuer = issuer.server.URL + "/"
+
+	if _, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier"); err == nil {
+		t.Fatal("discovery for another issuer was accepted")
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	env := map[string]string{
+		"OIDC_PROVIDERS":          "Corp, broken",
+		"OIDC_REDIRECT_BASE":      "https://chat.example.com/",
+		"OIDC_CORP_ISSUER":        "https://id.example.com",
+		"OIDC_CORP_CLIENT_ID":     "chat",
+		"OIDC_CORP_CLIENT_SECRET": "secret",
+	}
+	providers := FromEnv(func(key string) string { return env[key] })
+
+	corp, ok := providers["corp"]
+	if !ok || len(providers) != 1 {
+		t.Fatalf("providers %v", providers)
+	}
+	if corp.RedirectURL != "https://chat.example.com/auth/oidc/corp/callback" || corp.ClientId != "chat" {
+		t.Errorf("unexpected provider %+v", corp)
+	}
+}
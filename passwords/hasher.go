@@ -0,0 +1,181 @@
+// Package passwords hashes and checks user passwords. Hashes are stored in the modular crypt
+// format, naming the algorithm and its parameters, so hashes made under an older policy still
+// verify and can be told apart to be rehashed.
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrMismatch is returned when a password doesnt match its hash
+var ErrMismatch = errors.New("passwords: password does not match")
+
+// ErrUnknownHash is returned for hashes made by no supported algorithm
+var ErrUnknownHash = errors.New("passwords: unknown hash format")
+
+// Hasher hashes passwords under one policy
+type Hasher interface {
+	// Hash returns the encoded hash of the password
+	Hash(password string) (string, error)
+	// Verify checks a password against a hash this algorithm made, with any parameters
+	Verify(password string, encoded string) error
+	// Current tells if the hash was made with this hasher's algorithm and parameters
+	Current(encoded string) bool
+	// Handles tells if the hash was made by this algorithm
+	Handles(encoded string) bool
+}
+
+// Bcrypt hashes with bcrypt at a cost
+type Bcrypt struct {
+	Cost int
+}
+
+func (h Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+func (h Bcrypt) Verify(password string, encoded string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrMismatch
+	}
+	return err
+}
+
+func (h Bcrypt) Current(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err == nil && cost == h.Cost
+}
+
+func (Bcrypt) Handles(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// Argon2id hashes with argon2id, encoded as $argon2id$v=19$m=<KiB>,t=<passes>,p=<lanes>$<salt>$<key>
+type Argon2id struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// bounds on argon2id parameters, both configured ones and ones read back from hashes, so
+// every hash made can be verified and none can stall the server
+const (
+	maxArgon2Memory     = 4 << 20 // KiB
+	maxArgon2Iterations = 100
+)
+
+func validArgon2(memory int, iterations int, parallelism int) bool {
+	return memory > 0 && memory <= maxArgon2Memory &&
+		iterations > 0 && iterations <= maxArgon2Iterations &&
+		parallelism > 0 && parallelism <= 255
+}
+
+var argon2Encoding = base64.RawStdEncoding
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (h Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		argon2Encoding.EncodeToString(salt), argon2Encoding.EncodeToString(key)), nil
+}
+
+func (h Argon2id) Verify(password string, encoded string) error {
+	params, err := decodeArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+	key := argon2.IDKey([]byte(password), params.salt, params.iterations, params.memory, params.parallelism, uint32(len(params.key)))
+	if subtle.ConstantTimeCompare(key, params.key) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func (h Argon2id) Current(encoded string) bool {
+	params, err := decodeArgon2id(encoded)
+	return err == nil && params.memory == h.Memory && params.iterations == h.Iterations && params.parallelism == h.Parallelism
+}
+
+func (Argon2id) Handles(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func decodeArgon2id(encoded string) (*argon2Params, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrUnknownHash
+	}
+
+	params := &argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return nil, ErrUnknownHash
+	}
+	// parameters come from the database, but a corrupted one shouldnt be able to stall the server
+	if !validArgon2(int(params.memory), int(params.iterations), int(params.parallelism)) {
+		return nil, ErrUnknownHash
+	}
+
+	var err error
+	if params.salt, err = argon2Encoding.DecodeString(parts[4]); err != nil {
+		return nil, ErrUnknownHash
+	}
+	if params.key, err = argon2Encoding.DecodeString(parts[5]); err != nil || len(params.key) == 0 {
+		return nil, ErrUnknownHash
+	}
+	return params, nil
+}
+
+// known are the algorithms hashes can be verified with, whatever the current policy
+var known = []Hasher{Bcrypt{}, Argon2id{}}
+
+// Verify checks a password against a hash made by any supported algorithm
+func Verify(password string, encoded string) error {
+	for _, hasher := range known {
+		if hasher.Handles(encoded) {
+			return hasher.Verify(password, encoded)
+		}
+	}
+	return ErrUnknownHash
+}
+
+// NeedsRehash tells if a hash should be replaced by one made under the current policy
+func NeedsRehash(encoded string) bool {
+	return !Default.Current(encoded)
+}
+
+// Hash hashes a password under the current policy
+func Hash(password string) (string, error) {
+	return Default.Hash(password)
+}
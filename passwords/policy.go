@@ -0,0 +1,110 @@
+package passwords
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/achintya-7/go-fiber-chat/configs"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Default is the hasher new passwords are hashed with, picked with the PASSWORD_HASH env
+// variable: bcrypt (default) with BCRYPT_COST, or argon2id with ARGON2_MEMORY_KB,
+// ARGON2_ITERATIONS and ARGON2_PARALLELISM
+var Default Hasher = fromEnv()
+
+// the argon2id parameters used when the configured ones are unset or out of range
+const (
+	defaultArgon2Memory      = 64 * 1024
+	defaultArgon2Iterations  = 3
+	defaultArgon2Parallelism = 2
+)
+
+func fromEnv() Hasher {
+	switch configs.GetEnv("PASSWORD_HASH") {
+	case "argon2id":
+		memory := configs.GetEnvInt("ARGON2_MEMORY_KB", defaultArgon2Memory)
+		iterations := configs.GetEnvInt("ARGON2_ITERATIONS", defaultArgon2Iterations)
+		parallelism := configs.GetEnvInt("ARGON2_PARALLELISM", defaultArgon2Parallelism)
+		if !validArgon2(memory, iterations, parallelism) {
+			log.Printf("ARGON2_MEMORY_KB %d, ARGON2_ITERATIONS %d or ARGON2_PARALLELISM %d is out of range, using %d, %d and %d",
+				memory, iterations, parallelism, defaultArgon2Memory, defaultArgon2Iterations, defaultArgon2Parallelism)
+			memory, iterations, parallelism = defaultArgon2Memory, defaultArgon2Iterations, defaultArgon2Parallelism
+		}
+		return Argon2id{Memory: uint32(memory), Iterations: uint32(iterations), Parallelism: uint8(parallelism)}
+	default:
+		cost := configs.GetEnvInt("BCRYPT_COST", 12)
+		if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			log.Printf("BCRYPT_COST %d is out of range, using %d", cost, bcrypt.DefaultCost)
+			cost = bcrypt.DefaultCost
+		}
+		return Bcrypt{Cost: cost}
+	}
+}
+
+// MinLength is the fewest characters a password can have, from PASSWORD_MIN_LENGTH
+var MinLength = configs.GetEnvInt("PASSWORD_MIN_LENGTH", 8)
+
+// MaxBytes is the longest a password can be. bcrypt ignores everything after 72 bytes, a
+// longer password would seem stronger than it is.
+const MaxBytes = 72
+
+// breached are the sha1 sums of passwords known from breaches, read from the file at
+// PASSWORD_BREACHED_LIST. Each line is a password, or the uppercase hex sha1 of one with an
+// optional ":count" as in the Have I Been Pwned downloads.
+var breached = loadBreached(configs.GetEnv("PASSWORD_BREACHED_LIST"))
+
+func loadBreached(path string) map[[sha1.Size]byte]struct{} {
+	sums := map[[sha1.Size]byte]struct{}{}
+	if path == "" {
+		return sums
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Unable to read the breached password list: %v", err)
+		return sums
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if hash, _, _ := strings.Cut(line, ":"); len(hash) == 2*sha1.Size {
+			var sum [sha1.Size]byte
+			if _, err := hex.Decode(sum[:], []byte(hash)); err == nil {
+				sums[sum] = struct{}{}
+				continue
+			}
+		}
+		sums[sha1.Sum([]byte(line))] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Unable to read the breached password list: %v", err)
+	}
+	return sums
+}
+
+// Check tells why a password is too weak to use, or nil when it is fine
+func Check(password string) error {
+	if utf8.RuneCountInString(password) < MinLength {
+		return fmt.Errorf("password needs at least %d characters", MinLength)
+	}
+	if len(password) > MaxBytes {
+		return fmt.Errorf("password can be at most %d bytes long", MaxBytes)
+	}
+	if _, ok := breached[sha1.Sum([]byte(password))]; ok {
+		return errors.New("password appeared in a data breach, choose another one")
+	}
+	return nil
+}
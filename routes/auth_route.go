@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"github.com/achintya-7/go-fiber-chat/controllers"
+	"github.com/gofiber/fiber/v2"
+)
+
+func AuthRoute(app *fiber.App) {
+	app.Post("/auth/forgot-password", controllers.ForgotPassword)
+	app.Post("/auth/reset-password", controllers.ResetPassword)
+	app.Post("/auth/verify-email", controllers.VerifyEmail)
+	app.Post("/auth/2fa", controllers.VerifyTwoFactorSignIn)
+	app.Post("/auth/unlock", controllers.UnlockAccount)
+	app.Post("/auth/passkey/begin", controllers.BeginPasskeySignIn)
+	app.Post("/auth/passkey/finish", controllers.FinishPasskeySignIn)
+	app.Post("/auth/magic-link", controllers.RequestMagicLink)
+	app.Get("/auth/magic-link/verify", controllers.VerifyMagicLink)
+	app.Get("/auth/oidc/:provider", controllers.StartOIDCSignIn)
+	app.Get("/auth/oidc/:provider/callback", controllers.OIDCCallback)
+}
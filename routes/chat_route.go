@@ -2,14 +2,26 @@ package routes
 
 import (
 	"github.com/achintya-7/go-fiber-chat/controllers"
+	"github.com/achintya-7/go-fiber-chat/models"
 	"github.com/gofiber/fiber/v2"
 )
 
 func ChatRoute(app *fiber.App) {
 	app.Post("/create_chat", controllers.CreateChat)
-	app.Put("/add_to_group", controllers.AddToGroup)
+	app.Put("/add_to_group", controllers.RequireSignIn, controllers.AddToGroup)
 	app.Delete("/delete_from_group", controllers.DeleteFromGroup)
-	app.Get("/get_all_chats/:userId", controllers.GetAllChats)
-	app.Get("/get_all_messages/:chatId", controllers.GetAllMessages)
-	app.Post("/create_group_chat", controllers.CreateGroupChat)
+	app.Get("/get_all_chats/:userId", controllers.RequireSession, controllers.GetAllChats)
+	app.Get("/get_all_messages/:chatId", controllers.Authenticate(models.ScopeMessagesRead), controllers.GetAllMessages)
+	app.Post("/create_group_chat", controllers.RequireSignIn, controllers.CreateGroupChat)
+	app.Put("/chat_state/:userId/:chatId", controllers.RequireSession, controllers.UpdateChatState)
+	app.Post("/send_message", controllers.Authenticate(models.ScopeMessagesSend), controllers.SendMessage)
+	app.Post("/create_channel", controllers.RequireSignIn, controllers.CreateChannel)
+	app.Put("/channel/subscribe", controllers.RequireSignIn, controllers.SubscribeChannel)
+	app.Delete("/channel/unsubscribe", controllers.RequireSignIn, controllers.UnsubscribeChannel)
+	app.Put("/channel/add_admin", controllers.RequireSignIn, controllers.AddChannelAdmin)
+	app.Get("/groups/discover", controllers.DiscoverGroups)
+	app.Post("/groups/:chatId/join", controllers.RequireSignIn, controllers.JoinGroup)
+	app.Put("/groups/:chatId", controllers.RequireSignIn, controllers.UpdateGroup)
+	app.Post("/reaction", controllers.Authenticate(models.ScopeReactionsWrite), controllers.AddReaction)
+	app.Delete("/reaction", controllers.Authenticate(models.ScopeReactionsWrite), controllers.RemoveReaction)
 }
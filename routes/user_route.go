@@ -7,9 +7,42 @@ import (
 
 func UserRoute(app *fiber.App) {
 	app.Post("/user", controllers.CreateUser)
-	app.Get("/user/:userId", controllers.GetAUser)
-	app.Put("/user/:userId", controllers.EditAUser)
-	app.Delete("/user/:userId", controllers.DeleteAUser)
-	app.Get("/users", controllers.GetAllUsers)
+	app.Get("/user/:userId", controllers.RequireSignIn, controllers.GetAUser)
+	app.Patch("/user/:userId", controllers.RequireSession, controllers.UpdateUser)
+	app.Post("/user/:userId/password", controllers.RequireSession, controllers.ChangePassword)
+	app.Post("/user/:userId/email/resend_verification", controllers.RequireSession, controllers.ResendVerification)
+	app.Post("/user/:userId/2fa/enroll", controllers.RequireSession, controllers.EnrollTwoFactor)
+	app.Post("/user/:userId/2fa/confirm", controllers.RequireSession, controllers.ConfirmTwoFactor)
+	app.Delete("/user/:userId/2fa", controllers.RequireSession, controllers.DisableTwoFactor)
+	app.Post("/user/:userId/passkeys/begin", controllers.RequireSession, controllers.BeginPasskeyRegistration)
+	app.Post("/user/:userId/passkeys/finish", controllers.RequireSession, controllers.FinishPasskeyRegistration)
+	app.Get("/user/:userId/passkeys", controllers.RequireSession, controllers.GetPasskeys)
+	app.Delete("/user/:userId/passkeys/:credentialId", controllers.RequireSession, controllers.DeletePasskey)
+	app.Post("/user/:userId/avatar", controllers.RequireSession, controllers.UploadAvatar)
+	app.Post("/user/:userId/email/verify", controllers.RequireSession, controllers.VerifyEmailChange)
+	app.Delete("/user/:userId", controllers.RequireSession, controllers.DeleteAUser)
+	app.Get("/users", controllers.RequireSignIn, controllers.SearchUsers)
 	app.Post("/user/sign_in", controllers.SignInUser)
+	app.Post("/user/:userId/blocks", controllers.RequireSession, controllers.BlockUser)
+	app.Delete("/user/:userId/blocks/:targetId", controllers.RequireSession, controllers.UnblockUser)
+	app.Get("/user/:userId/blocks", controllers.RequireSession, controllers.GetBlockedUsers)
+	app.Post("/user/:userId/contacts/requests", controllers.RequireSession, controllers.SendContactRequest)
+	app.Get("/user/:userId/contacts/requests", controllers.RequireSession, controllers.GetContactRequests)
+	app.Post("/user/:userId/contacts/requests/:requestId/accept", controllers.RequireSession, controllers.AcceptContactRequest)
+	app.Post("/user/:userId/contacts/requests/:requestId/decline", controllers.RequireSession, controllers.DeclineContactRequest)
+	app.Get("/user/:userId/contacts", controllers.RequireSession, controllers.GetContacts)
+	app.Delete("/user/:userId/contacts/:contactId", controllers.RequireSession, controllers.RemoveContact)
+	app.Get("/user/:userId/group_invites", controllers.RequireSession, controllers.GetGroupInvites)
+	app.Post("/user/:userId/group_invites/:inviteId/accept", controllers.RequireSession, controllers.AcceptGroupInvite)
+	app.Post("/user/:userId/group_invites/:inviteId/decline", controllers.RequireSession, controllers.DeclineGroupInvite)
+	app.Post("/user/:userId/presence", controllers.RequireSession, controllers.Heartbeat)
+	app.Post("/user/:userId/export", controllers.RequireSession, controllers.ExportUserData)
+	app.Post("/user/:userId/bots", controllers.RequireSession, controllers.CreateBot)
+	app.Get("/user/:userId/bots", controllers.RequireSession, controllers.GetBots)
+	app.Delete("/user/:userId/bots/:botId", controllers.RequireSession, controllers.DeleteBot)
+	app.Post("/user/:userId/api_keys", controllers.RequireSession, controllers.CreateAPIKey)
+	app.Get("/user/:userId/api_keys", controllers.RequireSession, controllers.GetAPIKeys)
+	app.Delete("/user/:userId/api_keys/:keyId", controllers.RequireSession, controllers.RevokeAPIKey)
+	app.Get("/exports/:jobId", controllers.DownloadExport)
+	app.Get("/jobs/:jobId", controllers.GetJob)
 }
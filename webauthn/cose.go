@@ -0,0 +1,109 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm ids of the supported signature algorithms
+const (
+	AlgES256 int64 = -7
+	AlgRS256 int64 = -257
+)
+
+// COSE key parameters, see RFC 9053
+const (
+	coseKty   = 1
+	coseAlg   = 3
+	coseCrv   = -1 // EC2
+	coseX     = -2 // EC2
+	coseY     = -3 // EC2
+	coseN     = -1 // RSA
+	coseE     = -2 // RSA
+	ktyEC2    = 2
+	ktyRSA    = 3
+	crvP256   = 1
+	minRSABit = 2048
+)
+
+var errSignature = errors.New("webauthn: signature is invalid")
+
+// publicKey is a credential public key read from its COSE encoding
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parsePublicKey reads a COSE encoded public key
+func parsePublicKey(encoded []byte) (*publicKey, error) {
+	value, rest, err := decodeCBOR(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errCBOR
+	}
+	return publicKeyFromCOSE(value)
+}
+
+func publicKeyFromCOSE(value interface{}) (*publicKey, error) {
+	params, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("webauthn: public key is not a COSE key")
+	}
+	kty, _ := params[int64(coseKty)].(int64)
+	alg, _ := params[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == ktyEC2 && alg == AlgES256:
+		crv, _ := params[int64(coseCrv)].(int64)
+		x, _ := params[int64(coseX)].([]byte)
+		y, _ := params[int64(coseY)].([]byte)
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("webauthn: EC2 key is not on P-256")
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("webauthn: EC2 key is not on P-256")
+		}
+		return &publicKey{alg: alg, key: key}, nil
+	case kty == ktyRSA && alg == AlgRS256:
+		n, _ := params[int64(coseN)].([]byte)
+		e, _ := params[int64(coseE)].([]byte)
+		if len(n)*8 < minRSABit || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("webauthn: RSA key is too weak or malformed")
+		}
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+		if exponent < 3 {
+			return nil, errors.New("webauthn: RSA key is too weak or malformed")
+		}
+		return &publicKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}}, nil
+	default:
+		return nil, fmt.Errorf("webauthn: unsupported key type %d with algorithm %d", kty, alg)
+	}
+}
+
+// verify checks a signature over the data
+func (k *publicKey) verify(data []byte, signature []byte) error {
+	digest := sha256.Sum256(data)
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest[:], signature) {
+			return errSignature
+		}
+		return nil
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return errSignature
+		}
+		return nil
+	default:
+		return errSignature
+	}
+}
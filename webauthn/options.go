@@ -0,0 +1,107 @@
+package webauthn
+
+import "strings"
+
+// FromEnv reads this server's relying party, with getenv looking up env variables:
+// WEBAUTHN_RP_ID, WEBAUTHN_RP_NAME and WEBAUTHN_ORIGINS, a comma separated list. Passkeys are
+// off without an ID and origins.
+func FromEnv(getenv func(string) string) *RelyingParty {
+	rp := &RelyingParty{ID: getenv("WEBAUTHN_RP_ID"), Name: getenv("WEBAUTHN_RP_NAME")}
+	if rp.Name == "" {
+		rp.Name = "go-fiber-chat"
+	}
+	for _, origin := range strings.Split(getenv("WEBAUTHN_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			rp.Origins = append(rp.Origins, origin)
+		}
+	}
+	return rp
+}
+
+// Enabled tells if the relying party is configured
+func (rp *RelyingParty) Enabled() bool {
+	return rp.ID != "" && len(rp.Origins) > 0
+}
+
+// timeout the browser is given for a ceremony, in milliseconds
+const ceremonyTimeout = 5 * 60 * 1000
+
+// CredentialDescriptor names a credential, for the browser to pick or leave out
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	Id         Bytes    `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// CredentialParameter is a kind of credential the server accepts
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+// CreationOptions are passed to navigator.credentials.create as publicKey
+type CreationOptions struct {
+	RP struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		Id          Bytes  `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	Challenge              string                 `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+}
+
+// RequestOptions are passed to navigator.credentials.get as publicKey
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int                    `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// CreationOptions asks for a discoverable credential for the user, so they can later sign in
+// without typing their email. The challenge is the issued one, already base64url.
+func (rp *RelyingParty) CreationOptions(challenge string, userHandle []byte, name string, displayName string, exclude []CredentialDescriptor) CreationOptions {
+	var options CreationOptions
+	options.RP.ID = rp.ID
+	options.RP.Name = rp.Name
+	options.User.Id = userHandle
+	options.User.Name = name
+	options.User.DisplayName = displayName
+	options.Challenge = challenge
+	for _, alg := range []int64{AlgES256, AlgRS256} {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, CredentialParameter{Type: "public-key", Alg: alg})
+	}
+	options.Timeout = ceremonyTimeout
+	options.Attestation = "none"
+	options.ExcludeCredentials = exclude
+	if options.ExcludeCredentials == nil {
+		options.ExcludeCredentials = []CredentialDescriptor{}
+	}
+	options.AuthenticatorSelection.ResidentKey = "preferred"
+	options.AuthenticatorSelection.UserVerification = "preferred"
+	return options
+}
+
+// RequestOptions asks for any credential of this relying party, the browser offers the user
+// the passkeys they have
+func (rp *RelyingParty) RequestOptions(challenge string) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		RPID:             rp.ID,
+		Timeout:          ceremonyTimeout,
+		AllowCredentials: []CredentialDescriptor{},
+		UserVerification: "preferred",
+	}
+}
@@ -0,0 +1,234 @@
+// Package webauthn verifies passkey registrations and sign ins (WebAuthn Level 2). It
+// supports ES256 and RS256 credentials and "none" attestation, so it checks that a credential
+// works but not which authenticator made it.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Bytes is binary data sent to and from browsers as unpadded base64url
+type Bytes []byte
+
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(text, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// RelyingParty is this server as WebAuthn sees it. Credentials are bound to the ID, a domain,
+// and ceremonies are only accepted from the origins.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+// authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// Credential is a credential that passed registration
+type Credential struct {
+	Id        []byte
+	PublicKey []byte // COSE encoded
+	SignCount uint32
+	AAGUID    []byte
+	// the user proved who they are on the authenticator, with a PIN or biometrics
+	UserVerified bool
+}
+
+// Assertion is a sign in that passed verification
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authenticatorData struct {
+	rpIdHash  []byte
+	flags     byte
+	signCount uint32
+	// only with flagAttested
+	aaguid       []byte
+	credentialId []byte
+	publicKey    []byte
+}
+
+// Challenge reads the challenge out of a ceremony's client data, so the server can look up
+// what it issued before verifying the rest
+func Challenge(clientDataJSON []byte) (string, error) {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return "", errors.New("webauthn: client data is malformed")
+	}
+	return data.Challenge, nil
+}
+
+// VerifyRegistration checks a new credential made for the challenge
+func (rp *RelyingParty) VerifyRegistration(challenge string, clientDataJSON []byte, attestationObject []byte) (*Credential, error) {
+	if err := rp.checkClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	value, rest, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	attestation, ok := value.(map[interface{}]interface{})
+	if !ok || len(rest) != 0 {
+		return nil, errors.New("webauthn: attestation object is malformed")
+	}
+	// the server asks for no attestation, which browsers always honor
+	if format, _ := attestation["fmt"].(string); format != "none" {
+		return nil, errors.New("webauthn: only none attestation is supported")
+	}
+	rawAuthData, _ := attestation["authData"].([]byte)
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.checkAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+	if authData.flags&flagAttested == 0 {
+		return nil, errors.New("webauthn: registration has no credential")
+	}
+	if _, err := parsePublicKey(authData.publicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		Id:           authData.credentialId,
+		PublicKey:    authData.publicKey,
+		SignCount:    authData.signCount,
+		AAGUID:       authData.aaguid,
+		UserVerified: authData.flags&flagUserVerified != 0,
+	}, nil
+}
+
+// VerifyAssertion checks a sign in with a registered credential. The sign count has to grow
+// past the stored one, unless the authenticator doesnt count, or the credential was cloned.
+func (rp *RelyingParty) VerifyAssertion(challenge string, credentialPublicKey []byte, storedSignCount uint32, clientDataJSON []byte, rawAuthData []byte, signature []byte) (*Assertion, error) {
+	if err := rp.checkClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.checkAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+
+	key, err := parsePublicKey(credentialPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	if err := key.verify(append(append([]byte(nil), rawAuthData...), clientDataHash[:]...), signature); err != nil {
+		return nil, err
+	}
+
+	if (authData.signCount != 0 || storedSignCount != 0) && authData.signCount <= storedSignCount {
+		return nil, errors.New("webauthn: sign count did not increase, the authenticator may be cloned")
+	}
+
+	return &Assertion{SignCount: authData.signCount, UserVerified: authData.flags&flagUserVerified != 0}, nil
+}
+
+func (rp *RelyingParty) checkClientData(clientDataJSON []byte, ceremony string, challenge string) error {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return errors.New("webauthn: client data is malformed")
+	}
+	if data.Type != ceremony {
+		return errors.New("webauthn: client data is for another ceremony")
+	}
+	if challenge == "" || data.Challenge != challenge {
+		return errors.New("webauthn: challenge does not match")
+	}
+	for _, origin := range rp.Origins {
+		if data.Origin == origin {
+			return nil
+		}
+	}
+	return errors.New("webauthn: origin is not allowed")
+}
+
+func (rp *RelyingParty) checkAuthenticatorData(data *authenticatorData) error {
+	rpIdHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(data.rpIdHash, rpIdHash[:]) {
+		return errors.New("webauthn: credential is for another relying party")
+	}
+	if data.flags&flagUserPresent == 0 {
+		return errors.New("webauthn: user was not present")
+	}
+	return nil
+}
+
+// parseAuthenticatorData reads rpIdHash(32) flags(1) signCount(4) and, when attested,
+// aaguid(16) credentialIdLength(2) credentialId and the COSE public key
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	malformed := errors.New("webauthn: authenticator data is malformed")
+	if len(data) < 37 {
+		return nil, malformed
+	}
+
+	parsed := &authenticatorData{
+		rpIdHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if parsed.flags&flagAttested == 0 {
+		return parsed, nil
+	}
+
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, malformed
+	}
+	parsed.aaguid = rest[:16]
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+		return nil, malformed
+	}
+	parsed.credentialId = rest[:idLength]
+	rest = rest[idLength:]
+
+	// the key is followed by extensions, if any, so its length is what the decoder used up
+	_, after, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, malformed
+	}
+	parsed.publicKey = rest[:len(rest)-len(after)]
+	return parsed, nil
+}
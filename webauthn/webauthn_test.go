@@ -0,0 +1,323 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testOrigin = "https://chat.example.com"
+
+func testRelyingParty() *RelyingParty {
+	return &RelyingParty{ID: "chat.example.com", Name: "chat", Origins: []string{testOrigin}}
+}
+
+// authenticator is a software ES256 authenticator, making credentials and signing assertions
+// the way a browser and security key would
+type authenticator struct {
+	rpId         string
+	origin       string
+	key          *ecdsa.PrivateKey
+	credentialId []byte
+	signCount    uint32
+}
+
+func newAuthenticator(t *testing.T, rpId string, origin string) *authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+	return &authenticator{rpId: rpId, origin: origin, key: key, credentialId: id}
+}
+
+func newChallenge(t *testing.T) string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func (a *authenticator) clientData(ceremony string, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{"type": ceremony, "challenge": challenge, "origin": a.origin})
+	return data
+}
+
+// authData is rpIdHash, flags and the sign count, followed by attested credential data when
+// attested is set
+func (a *authenticator) authData(attested bool) []byte {
+	rpIdHash := sha256.Sum256([]byte(a.rpId))
+	flags := byte(flagUserPresent | flagUserVerified)
+	if attested {
+		flags |= flagAttested
+	}
+	data := append(rpIdHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if !attested {
+		return data
+	}
+
+	data = append(data, make([]byte, 16)...) // aaguid
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialId)))
+	data = append(data, a.credentialId...)
+	return append(data, encodeCBOR(map[interface{}]interface{}{
+		int64(coseKty): int64(ktyEC2),
+		int64(coseAlg): AlgES256,
+		int64(coseCrv): int64(crvP256),
+		int64(coseX):   a.key.X.FillBytes(make([]byte, 32)),
+		int64(coseY):   a.key.Y.FillBytes(make([]byte, 32)),
+	})...)
+}
+
+// register answers navigator.credentials.create
+func (a *authenticator) register(challenge string) (clientDataJSON []byte, attestationObject []byte) {
+	attestationObject = encodeCBOR(map[interface{}]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[interface{}]interface{}{},
+		"authData": a.authData(true),
+	})
+	return a.clientData("webauthn.create", challenge), attestationObject
+}
+
+// sign answers navigator.credentials.get, counting up first
+func (a *authenticator) sign(t *testing.T, challenge string) (clientDataJSON []byte, authData []byte, signature []byte) {
+	a.signCount++
+	clientDataJSON = a.clientData("webauthn.get", challenge)
+	authData = a.authData(false)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientDataJSON, authData, signature
+}
+
+// encodeCBOR writes the few CBOR types authenticators use, with map keys in canonical order
+func encodeCBOR(value interface{}) []byte {
+	head := func(major byte, arg uint64) []byte {
+		switch {
+		case arg < 24:
+			return []byte{major<<5 | byte(arg)}
+		case arg <= 0xff:
+			return []byte{major<<5 | 24, byte(arg)}
+		case arg <= 0xffff:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+		default:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+		}
+	}
+
+	switch v := value.(type) {
+	case int64:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[interface{}]interface{}:
+		entries := make([][2][]byte, 0, len(v))
+		for key, item := range v {
+			entries = append(entries, [2][]byte{encodeCBOR(key), encodeCBOR(item)})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			if len(entries[i][0]) != len(entries[j][0]) {
+				return len(entries[i][0]) < len(entries[j][0])
+			}
+			return string(entries[i][0]) < string(entries[j][0])
+		})
+		out := head(5, uint64(len(v)))
+		for _, entry := range entries {
+			out = append(append(out, entry[0]...), entry[1]...)
+		}
+		return out
+	default:
+		panic("encodeCBOR: unsupported type")
+	}
+}
+
+// registered makes a credential with the authenticator and checks it passes registration
+func registered(t *testing.T, rp *RelyingParty, a *authenticator) *Credential {
+	challenge := newChallenge(t)
+	clientDataJSON, attestationObject := a.register(challenge)
+	credential, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return credential
+}
+
+func TestRegistration(t *testing.T) {
+	rp := testRelyingParty()
+	a := newAuthenticator(t, rp.ID, testOrigin)
+
+	credential := registered(t, rp, a)
+	if string(credential.Id) != string(a.credentialId) || !credential.UserVerified || credential.SignCount != 0 {
+		t.Errorf("unexpected credential %+v", credential)
+	}
+
+	key, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := key.key.(*ecdsa.PublicKey)
+	if stored.X.Cmp(a.key.X) != 0 || stored.Y.Cmp(a.key.Y) != 0 {
+		t.Error("stored public key is not the authenticator's")
+	}
+}
+
+func TestAssertion(t *testing.T) {
+	rp := testRelyingParty()
+	a := newAuthenticator(t, rp.ID, testOrigin)
+	credential := registered(t, rp, a)
+
+	challenge := newChallenge(t)
+	clientDataJSON, authData, signature := a.sign(t, challenge)
+
+	read, err := Challenge(clientDataJSON)
+	if err != nil || read != challenge {
+		t.Fatalf("read challenge %q, %v", read, err)
+	}
+
+	assertion, err := rp.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount, clientDataJSON, authData, signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assertion.SignCount != 1 || !assertion.UserVerified {
+		t.Errorf("unexpected assertion %+v", assertion)
+	}
+}
+
+func TestAssertionSignCountRegression(t *testing.T) {
+	rp := testRelyingParty()
+	a := newAuthenticator(t, rp.ID, testOrigin)
+	credential := registered(t, rp, a)
+
+	// the server saw a later count than this authenticator has, as if it were a clone
+	challenge := newChallenge(t)
+	clientDataJSON, authData, signature := a.sign(t, challenge)
+	_, err := rp.VerifyAssertion(challenge, credential.PublicKey, 5, clientDataJSON, authData, signature)
+	if err == nil || !strings.Contains(err.Error(), "sign count") {
+		t.Fatalf("assertion passed with a lower sign count: %v", err)
+	}
+}
+
+func TestAssertionReplay(t *testing.T) {
+	rp := testRelyingParty()
+	a := newAuthenticator(t, rp.ID, testOrigin)
+	credential := registered(t, rp, a)
+
+	challenge := newChallenge(t)
+	clientDataJSON, authData, signature := a.sign(t, challenge)
+	assertion, err := rp.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount, clientDataJSON, authData, signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sent again for the next sign in's challenge, the old one was used up
+	if _, err := rp.VerifyAssertion(newChallenge(t), credential.PublicKey, assertion.SignCount, clientDataJSON, authData, signature); err == nil || !strings.Contains(err.Error(), "challenge") {
+		t.Fatalf("replayed assertion passed with a new challenge: %v", err)
+	}
+	// sent again for the same challenge, the stored sign count has caught up with it
+	if _, err := rp.VerifyAssertion(challenge, credential.PublicKey, assertion.SignCount, clientDataJSON, authData, signature); err == nil || !strings.Contains(err.Error(), "sign count") {
+		t.Fatalf("replayed assertion passed with the same challenge: %v", err)
+	}
+}
+
+func TestWrongOrigin(t *testing.T) {
+	rp := testRelyingParty()
+	phishing := newAuthenticator(t, rp.ID, "https://chat.example.com.evil.test")
+
+	challenge := newChallenge(t)
+	clientDataJSON, attestationObject := phishing.register(challenge)
+	if _, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject); err == nil || !strings.Contains(err.Error(), "origin") {
+		t.Fatalf("registration passed from another origin: %v", err)
+	}
+
+	a := newAuthenticator(t, rp.ID, testOrigin)
+	credential := registered(t, rp, a)
+	a.origin = phishing.origin
+	clientDataJSON, authData, signature := a.sign(t, challenge)
+	if _, err := rp.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount, clientDataJSON, authData, signature); err == nil || !strings.Contains(err.Error(), "origin") {
+		t.Fatalf("assertion passed from another origin: %v", err)
+	}
+}
+
+func TestWrongRelyingPartyId(t *testing.T) {
+	rp := testRelyingParty()
+	other := newAuthenticator(t, "example.org", testOrigin)
+
+	challenge := newChallenge(t)
+	clientDataJSON, attestationObject := other.register(challenge)
+	if _, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject); err == nil || !strings.Contains(err.Error(), "relying party") {
+		t.Fatalf("registration passed for another relying party: %v", err)
+	}
+
+	a := newAuthenticator(t, rp.ID, testOrigin)
+	credential := registered(t, rp, a)
+	a.rpId = other.rpId
+	clientDataJSON, authData, signature := a.sign(t, challenge)
+	if _, err := rp.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount, clientDataJSON, authData, signature); err == nil || !strings.Contains(err.Error(), "relying party") {
+		t.Fatalf("assertion passed for another relying party: %v", err)
+	}
+}
+
+func TestWrongCeremonyAndSignature(t *testing.T) {
+	rp := testRelyingParty()
+	a := newAuthenticator(t, rp.ID, testOrigin)
+	credential := registered(t, rp, a)
+
+	// client data of a registration cant be used to sign in
+	challenge := newChallenge(t)
+	clientDataJSON, _ := a.register(challenge)
+	_, authData, signature := a.sign(t, challenge)
+	if _, err := rp.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount, clientDataJSON, authData, signature); err == nil {
+		t.Fatal("assertion passed with registration client data")
+	}
+
+	// another key's signature
+	impostor := newAuthenticator(t, rp.ID, testOrigin)
+	impostor.signCount = a.signCount
+	clientDataJSON, authData, signature = impostor.sign(t, challenge)
+	if _, err := rp.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount, clientDataJSON, authData, signature); err != errSignature {
+		t.Fatalf("assertion passed with another key's signature: %v", err)
+	}
+}
+
+func TestWeakRSAKeyRejected(t *testing.T) {
+	key := encodeCBOR(map[interface{}]interface{}{
+		int64(coseKty): int64(ktyRSA),
+		int64(coseAlg): AlgRS256,
+		int64(coseN):   big.NewInt(3233).Bytes(),
+		int64(coseE):   big.NewInt(65537).Bytes(),
+	})
+	if _, err := parsePublicKey(key); err == nil {
+		t.Fatal("a tiny RSA key was accepted")
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	env := map[string]string{"WEBAUTHN_RP_ID": "chat.example.com", "WEBAUTHN_ORIGINS": " https://chat.example.com, ,https://app.example.com"}
+	rp := FromEnv(func(key string) string { return env[key] })
+	if !rp.Enabled() || rp.Name != "go-fiber-chat" || len(rp.Origins) != 2 || rp.Origins[1] != "https://app.example.com" {
+		t.Errorf("unexpected relying party %+v", rp)
+	}
+	if FromEnv(func(string) string { return "" }).Enabled() {
+		t.Error("relying party without an ID is enabled")
+	}
+}